@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/FlowerWrong/tun2socks/configure"
+)
+
+const configUsage = `Usage:
+  tun2socks config convert [-o out.yaml] [-to yaml] config.ini
+  tun2socks config clash [-o config.ini] [-to ini] clash.yaml
+  tun2socks config surge [-o config.ini] [-to ini] surge.conf`
+
+// configMain run `tun2socks config <convert|clash|surge>`.
+// convert converts ini to yaml and yaml or json to ini, clash and surge import a foreign config and report the skipped entries.
+func configMain(args []string) int {
+	imports := map[string]func(*configure.AppConfig, string) ([]configure.Skipped, error){
+		"clash": (*configure.AppConfig).ImportClash,
+		"surge": (*configure.AppConfig).ImportSurge,
+	}
+	if len(args) == 0 || (args[0] != "convert" && imports[args[0]] == nil) {
+		fmt.Fprintln(os.Stderr, configUsage)
+		return 2
+	}
+	fs := flag.NewFlagSet("config "+args[0], flag.ExitOnError)
+	var out, to string
+	fs.StringVar(&out, "o", "", "output file, default stdout")
+	fs.StringVar(&to, "to", "", "output format, ini or yaml, default by the extension of -o")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, configUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	in := fs.Arg(0)
+
+	if to == "" {
+		switch {
+		case out != "":
+			to = configure.FormatOf(out)
+		case args[0] == "convert" && configure.FormatOf(in) == configure.FormatINI:
+			to = configure.FormatYAML
+		default:
+			to = configure.FormatINI
+		}
+	}
+	if to != configure.FormatINI && to != configure.FormatYAML {
+		fmt.Fprintf(os.Stderr, "can not convert to %q, ini or yaml\n", to)
+		return 2
+	}
+
+	cfg := new(configure.AppConfig)
+	if importFile := imports[args[0]]; importFile != nil {
+		skipped, err := importFile(cfg, in)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for _, s := range skipped {
+			fmt.Fprintf(os.Stderr, "skipped %s\n", s)
+		}
+	} else if err := cfg.Decode(in); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	write := cfg.WriteYAML
+	if to == configure.FormatINI {
+		write = cfg.WriteINI
+	}
+	if err := write(w); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
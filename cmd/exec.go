@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// execMain run `tun2socks exec -c config.ini -- <cmd> [args...]`, it return the exit code of cmd
+func execMain(args []string) int {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	var configFile string
+	fs.StringVar(&configFile, "c", "", "config file")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: tun2socks exec -c config.ini -- <cmd> [args...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if configFile == "" {
+		configFile = defaultConfigFile()
+	}
+
+	log.Println("[app] config file path is", configFile)
+	code, err := app.Exec(context.Background(), configFile, fs.Args())
+	if err != nil {
+		log.Println("[exec]", err)
+		if code < 0 {
+			return 1
+		}
+	}
+	return code
+}
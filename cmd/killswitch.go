@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/FlowerWrong/tun2socks/tun2socks"
+)
+
+// killSwitchMain run `tun2socks killswitch off`, which removes the kill switch left by a crashed tun2socks
+func killSwitchMain(args []string) int {
+	if len(args) != 1 || args[0] != "off" {
+		fmt.Fprintln(os.Stderr, "Usage: tun2socks killswitch off")
+		return 2
+	}
+	if err := tun2socks.KillSwitchOff(); err != nil {
+		log.Println("[killswitch]", err)
+		return 1
+	}
+	log.Println("[killswitch] off")
+	return 0
+}
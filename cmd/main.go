@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -9,6 +10,8 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/FlowerWrong/tun2socks/configure"
+	"github.com/FlowerWrong/tun2socks/dns"
 	"github.com/FlowerWrong/tun2socks/tun2socks"
 	"github.com/fatih/color"
 )
@@ -16,15 +19,34 @@ import (
 var app = new(tun2socks.App)
 
 func main() {
+	// the child of exec, which runs the command in the network namespace
+	if tun2socks.IsExecChild() {
+		log.Fatal("[exec] ", tun2socks.ExecChild(os.Args[1:]))
+	}
+
 	rand.Seed(time.Now().UnixNano())
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
 	app.Version = 0.5
-	var version, help bool
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "exec":
+			os.Exit(execMain(os.Args[2:]))
+		case "killswitch":
+			os.Exit(killSwitchMain(os.Args[2:]))
+		case "config":
+			os.Exit(configMain(os.Args[2:]))
+		}
+	}
+
+	var version, help, test bool
 	var configFile string
+	var tunFd int
 	flag.BoolVar(&version, "v", false, "show version and exit")
 	flag.StringVar(&configFile, "c", "", "config file")
+	flag.IntVar(&tunFd, "tun-fd", -1, "use an already opened tun fd, interface and routes will not be set up")
+	flag.BoolVar(&test, "t", false, "check the config file and exit, no tun is created")
 	flag.BoolVar(&help, "h", false, "help")
 	flag.Parse()
 
@@ -36,19 +58,64 @@ func main() {
 	if help {
 		fmt.Printf("Version: %s\n", color.New(color.Bold, color.FgGreen).SprintFunc()("sudo go run cmd/main.go -v"))
 		fmt.Printf("Usage: %s\n", color.New(color.Bold, color.FgGreen).SprintFunc()("sudo go run cmd/main.go -c=config.example.ini"))
+		fmt.Printf("Exec: %s\n", color.New(color.Bold, color.FgGreen).SprintFunc()("sudo go run cmd/main.go exec -c=config.example.ini -- curl https://www.google.com"))
+		fmt.Printf("Check config: %s\n", color.New(color.Bold, color.FgGreen).SprintFunc()("go run cmd/main.go -t -c=config.example.ini"))
+		fmt.Printf("Convert config: %s\n", color.New(color.Bold, color.FgGreen).SprintFunc()("go run cmd/main.go config convert -o config.yaml config.example.ini"))
+		fmt.Printf("Import clash: %s\n", color.New(color.Bold, color.FgGreen).SprintFunc()("go run cmd/main.go config clash -o config.ini clash.yaml"))
+		fmt.Printf("Import surge: %s\n", color.New(color.Bold, color.FgGreen).SprintFunc()("go run cmd/main.go config surge -o config.ini surge.conf"))
+		fmt.Printf("Kill switch off: %s\n", color.New(color.Bold, color.FgGreen).SprintFunc()("sudo go run cmd/main.go killswitch off"))
 		os.Exit(0)
 	}
 
 	if configFile == "" {
 		configFile = flag.Arg(0)
 		if configFile == "" {
-			if runtime.GOOS == "linux" {
-				configFile = "/home/" + os.Getenv("SUDO_USER") + "/.tun2socks/config.ini"
-			} else if runtime.GOOS == "darwin" {
-				configFile = "/Users/" + os.Getenv("SUDO_USER") + "/.tun2socks/config.ini"
-			}
+			configFile = defaultConfigFile()
 		}
 	}
+	if test {
+		os.Exit(checkConfig(configFile))
+	}
 	log.Println("[app] config file path is", configFile)
-	app.StartTun2socks(configFile)
+	var err error
+	if tunFd >= 0 {
+		err = app.StartTun2socksWithFd(context.Background(), configFile, tunFd)
+	} else {
+		err = app.StartTun2socks(context.Background(), configFile)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// checkConfig parse and validate the config file, print every invalid value and return the exit code
+func checkConfig(configFile string) int {
+	cfg := new(configure.AppConfig)
+	err := cfg.Parse(configFile)
+	if err == nil {
+		// read the rule-set files of patterns
+		_, err = dns.NewRule(cfg.Rule, cfg.Pattern)
+	}
+	if err == nil {
+		fmt.Printf("%s: ok\n", configFile)
+		return 0
+	}
+	if errs, ok := err.(configure.CheckError); ok {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", configFile, e)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", configFile, err)
+	}
+	return 1
+}
+
+// defaultConfigFile is ~/.tun2socks/config.ini of the sudo user
+func defaultConfigFile() string {
+	if runtime.GOOS == "linux" {
+		return "/home/" + os.Getenv("SUDO_USER") + "/.tun2socks/config.ini"
+	} else if runtime.GOOS == "darwin" {
+		return "/Users/" + os.Getenv("SUDO_USER") + "/.tun2socks/config.ini"
+	}
+	return ""
 }
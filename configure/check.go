@@ -0,0 +1,262 @@
+package configure
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/FlowerWrong/tun2socks/util"
+)
+
+// pattern schemes
+const (
+	SchemeDomainSuffix  = "DOMAIN-SUFFIX"
+	SchemeDomainKeyword = "DOMAIN-KEYWORD"
+	SchemeIPCountry     = "IP-COUNTRY"
+	SchemeIPCIDR        = "IP-CIDR"
+)
+
+// ProxyBlock is the proxy of patterns whose domains are blocked
+const ProxyBlock = "block"
+
+var dnsModes = []string{"fake", "udp_relay_via_socks5"}
+
+// FieldError is an invalid value of a key in a section
+type FieldError struct {
+	Section string // eg: general, proxy "A"
+	Key     string
+	Err     error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("[%s] %s: %v", e.Section, e.Key, e.Err)
+}
+
+// CheckError is all the invalid values of a config
+type CheckError []*FieldError
+
+func (e CheckError) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// checker collect the field errors of a config
+type checker struct {
+	errs CheckError
+}
+
+func (c *checker) errorf(section, key, format string, a ...interface{}) {
+	c.errs = append(c.errs, &FieldError{Section: section, Key: key, Err: fmt.Errorf(format, a...)})
+}
+
+// check the semantic of the config, all the invalid values are returned as a CheckError
+func (cfg *AppConfig) check() error {
+	c := new(checker)
+	cfg.checkGeneral(c)
+	cfg.checkDNS(c)
+	cfg.checkProxy(c)
+	cfg.checkRule(c)
+	cfg.checkRoute(c)
+
+	if cfg.TCP.MSS != 0 && (cfg.TCP.MSS < 88 || uint32(cfg.TCP.MSS)+40 > cfg.General.Mtu) {
+		c.errorf("tcp", "mss", "%d is not in 88 to mtu-40", cfg.TCP.MSS)
+	}
+	if cfg.TCP.Timeout <= 0 {
+		c.errorf("tcp", "timeout", "%d is not positive", cfg.TCP.Timeout)
+	}
+	if cfg.UDP.Timeout <= 0 {
+		c.errorf("udp", "timeout", "%d is not positive", cfg.UDP.Timeout)
+	}
+	if cfg.UDP.Proxy != "" && cfg.Proxy[cfg.UDP.Proxy] == nil {
+		c.errorf("udp", "proxy", "unknown proxy %q", cfg.UDP.Proxy)
+	}
+	if cfg.Pprof.Enabled && cfg.Pprof.ProfPort == 0 {
+		c.errorf("pprof", "prof-port", "0 is not a port")
+	}
+
+	if len(c.errs) > 0 {
+		return c.errs
+	}
+	return nil
+}
+
+// checkEmpty report the proxies and patterns without keys, a yaml or json entry without a body is decoded to nil
+func (cfg *AppConfig) checkEmpty() error {
+	c := new(checker)
+	for _, name := range sortedKeys(cfg.Proxy) {
+		if cfg.Proxy[name] == nil {
+			c.errorf(fmt.Sprintf("proxy %q", name), "url", "empty proxy, url is required")
+		}
+	}
+	for _, name := range sortedKeys(cfg.Pattern) {
+		if cfg.Pattern[name] == nil {
+			c.errorf(fmt.Sprintf("pattern %q", name), "scheme", "empty pattern, scheme is required")
+		}
+	}
+	if len(c.errs) > 0 {
+		return c.errs
+	}
+	return nil
+}
+
+func (cfg *AppConfig) checkGeneral(c *checker) {
+	g := cfg.General
+	if ip, _, err := net.ParseCIDR(g.Network); err != nil || ip.To4() == nil {
+		c.errorf("general", "network", "%q is not an ipv4 cidr, eg: 198.18.0.0/15", g.Network)
+	}
+	if g.Mtu < 576 || g.Mtu > 65535 {
+		c.errorf("general", "mtu", "%d is not in 576 to 65535", g.Mtu)
+	}
+	if g.GracePeriod < 0 {
+		c.errorf("general", "grace-period", "%d is negative", g.GracePeriod)
+	}
+	if g.TunQueues < 1 {
+		c.errorf("general", "tun-queues", "%d is less than 1", g.TunQueues)
+	}
+}
+
+func (cfg *AppConfig) checkDNS(c *checker) {
+	d := cfg.DNS
+	if !contains(dnsModes, d.DNSMode) {
+		c.errorf("dns", "dns-mode", "unknown mode %q, one of %s", d.DNSMode, strings.Join(dnsModes, ", "))
+	}
+	if d.DNSPort == 0 {
+		c.errorf("dns", "dns-port", "0 is not a port")
+	}
+	for _, ns := range d.Nameserver {
+		host, port, err := net.SplitHostPort(ns)
+		if err != nil {
+			c.errorf("dns", "nameserver", "%q is not ip:port, eg: 8.8.8.8:53", ns)
+			continue
+		}
+		if net.ParseIP(host) == nil {
+			c.errorf("dns", "nameserver", "%q is not an ip", host)
+		}
+		if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+			c.errorf("dns", "nameserver", "%q of %q is not a port", port, ns)
+		}
+	}
+}
+
+func (cfg *AppConfig) checkProxy(c *checker) {
+	if len(cfg.Proxy) == 0 {
+		c.errorf("proxy", "url", "no proxy, at least one [proxy \"name\"] is required")
+	}
+	var defaults []string
+	for _, name := range sortedKeys(cfg.Proxy) {
+		p := cfg.Proxy[name]
+		section := fmt.Sprintf("proxy %q", name)
+		if p == nil {
+			c.errorf(section, "url", "empty proxy, url is required")
+			continue
+		}
+		u, err := url.Parse(p.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			c.errorf(section, "url", "%q is not a proxy url, eg: socks5://127.0.0.1:1080", p.URL)
+		}
+		if p.Default {
+			defaults = append(defaults, name)
+		}
+	}
+	if len(defaults) > 1 {
+		c.errorf("proxy", "default", "proxies %s are all default", strings.Join(defaults, ", "))
+	}
+}
+
+func (cfg *AppConfig) checkRule(c *checker) {
+	schemes := []string{SchemeDomainSuffix, SchemeDomainKeyword, SchemeIPCountry, SchemeIPCIDR}
+	for _, name := range sortedKeys(cfg.Pattern) {
+		p := cfg.Pattern[name]
+		section := fmt.Sprintf("pattern %q", name)
+		if p == nil {
+			c.errorf(section, "scheme", "empty pattern, scheme is required")
+			continue
+		}
+		if !contains(schemes, p.Scheme) {
+			c.errorf(section, "scheme", "unknown scheme %q, one of %s", p.Scheme, strings.Join(schemes, ", "))
+		}
+		if !cfg.isProxy(p.Proxy) {
+			c.errorf(section, "proxy", "unknown proxy %q", p.Proxy)
+		}
+		for _, rawurl := range p.URL {
+			if u, err := url.Parse(rawurl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				c.errorf(section, "url", "%q is not a http or https url", rawurl)
+			}
+		}
+		if p.Interval < 0 {
+			c.errorf(section, "interval", "%d is negative", p.Interval)
+		}
+		if p.Scheme == SchemeIPCIDR {
+			for _, v := range p.V {
+				if _, _, err := net.ParseCIDR(v); err != nil {
+					c.errorf(section, "v", "%q is not a cidr", v)
+				}
+			}
+		}
+	}
+	for _, name := range cfg.Rule.Pattern {
+		if cfg.Pattern[name] == nil {
+			c.errorf("rule", "pattern", "unknown pattern %q", name)
+		}
+	}
+	if !cfg.isProxy(cfg.Rule.Final) {
+		c.errorf("rule", "final", "unknown proxy %q", cfg.Rule.Final)
+	}
+}
+
+func (cfg *AppConfig) checkRoute(c *checker) {
+	r := cfg.Route
+	if r.Table <= 0 {
+		c.errorf("route", "table", "%d is not positive", r.Table)
+	}
+	if r.RulePriority <= 0 {
+		c.errorf("route", "rule-priority", "%d is not positive", r.RulePriority)
+	}
+	if (r.AutoRoute || r.KillSwitch) && r.FWMark == 0 {
+		c.errorf("route", "fwmark", "0 does not mark, it is required by auto-route and kill-switch")
+	}
+	if len(r.Cgroup) > 0 && (r.SplitMark == 0 || r.SplitMark == r.FWMark) {
+		c.errorf("route", "split-mark", "%d must not be 0 or fwmark", r.SplitMark)
+	}
+	for _, uid := range r.UID {
+		if _, err := util.ParseUIDRange(uid); err != nil {
+			c.errorf("route", "uid", "%v", err)
+		}
+	}
+}
+
+// isProxy report whether name is a proxy of patterns and rule, empty means direct
+func (cfg *AppConfig) isProxy(name string) bool {
+	return name == "" || name == ProxyBlock || cfg.Proxy[name] != nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]*ProxyConfig:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*PatternConfig:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,121 @@
+package configure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validConfig() *AppConfig {
+	cfg := new(AppConfig)
+	cfg.General.Network = "198.18.0.0/15"
+	cfg.General.Mtu = 1500
+	cfg.General.GracePeriod = 5
+	cfg.General.TunQueues = 1
+	cfg.Pprof.ProfPort = 6060
+	cfg.DNS.DNSMode = "fake"
+	cfg.DNS.DNSPort = DNSDefaultPort
+	cfg.DNS.Nameserver = []string{"8.8.8.8:53"}
+	cfg.TCP.Timeout = 60
+	cfg.UDP.Timeout = 300
+	cfg.Route.Table = 2022
+	cfg.Route.FWMark = 2022
+	cfg.Route.RulePriority = 9000
+	cfg.Route.SplitMark = 2023
+	cfg.Proxy = map[string]*ProxyConfig{"A": {URL: "socks5://127.0.0.1:1080", Default: true}}
+	cfg.Pattern = map[string]*PatternConfig{
+		"direct": {Scheme: SchemeDomainSuffix, V: []string{"cn"}},
+		"proxy":  {Scheme: SchemeIPCIDR, Proxy: "A", V: []string{"91.108.4.0/22"}},
+	}
+	cfg.Rule.Pattern = []string{"direct", "proxy"}
+	cfg.Rule.Final = "A"
+	return cfg
+}
+
+func TestCheck(t *testing.T) {
+	assert.Nil(t, validConfig().check())
+
+	cfg := validConfig()
+	cfg.General.Network = "fd00::/64"
+	cfg.DNS.DNSMode = "tcp"
+	cfg.DNS.Nameserver = []string{"8.8.8.8", "dns.google:53"}
+	cfg.Proxy["B"] = &ProxyConfig{URL: "127.0.0.1:1080", Default: true}
+	cfg.Pattern["proxy"].Scheme = "IP"
+	cfg.Pattern["proxy"].Proxy = "C"
+	cfg.Rule.Pattern = append(cfg.Rule.Pattern, "missing")
+	cfg.Rule.Final = "block"
+	cfg.UDP.Proxy = "C"
+	cfg.Route.UID = []string{"1000-"}
+	cfg.Route.Cgroup = []string{"user.slice"}
+	cfg.Route.SplitMark = cfg.Route.FWMark
+
+	err := cfg.check()
+	errs, ok := err.(CheckError)
+	if !assert.True(t, ok, "%v", err) {
+		return
+	}
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Section+" "+e.Key)
+	}
+	assert.Equal(t, []string{
+		"general network",
+		"dns dns-mode",
+		"dns nameserver",
+		"dns nameserver",
+		`proxy "B" url`,
+		"proxy default",
+		`pattern "proxy" scheme`,
+		`pattern "proxy" proxy`,
+		"rule pattern",
+		"route split-mark",
+		"route uid",
+		"udp proxy",
+	}, fields)
+	assert.Equal(t, `[rule] pattern: unknown pattern "missing"`, errs[8].Error())
+}
+
+func TestCheckMarkedProxy(t *testing.T) {
+	cfg := validConfig()
+	cfg.Proxy["B"] = &ProxyConfig{URL: "http://127.0.0.1:8080"}
+	assert.Nil(t, cfg.check())
+
+	// http proxies are dialed with fwmark too
+	cfg.Route.UID = []string{"1000"}
+	assert.Nil(t, cfg.check())
+}
+
+func TestCheckRoutes(t *testing.T) {
+	cfg := validConfig()
+	cfg.Route.V = []string{"8.8.8.8", "10.0.0.0/8"}
+	cfg.Route.Exclude = []string{"10.0.0.0/9"}
+	assert.Nil(t, cfg.loadRoutes())
+	assert.Equal(t, []string{"8.8.8.8/32", "10.128.0.0/9"}, cfg.Routes)
+
+	cfg.Route.Exclude = []string{"10.0.0.0/33"}
+	err := cfg.loadRoutes()
+	if assert.IsType(t, &FieldError{}, err) {
+		assert.Equal(t, "exclude", err.(*FieldError).Key)
+	}
+}
+
+func TestRouteCountry(t *testing.T) {
+	list := writeTemp(t, "delegated-apnic-latest", `apnic|CN|ipv4|1.0.1.0|256|20110414|allocated
+apnic|JP|ipv4|1.0.16.0|4096|20110412|allocated
+`)
+	defer os.RemoveAll(filepath.Dir(list))
+
+	// every country by default
+	cfg := new(AppConfig)
+	cfg.setDefaults()
+	assert.Equal(t, "", cfg.Route.Country)
+	cfg.Route.File = []string{list}
+	assert.Nil(t, cfg.loadRoutes())
+	assert.Equal(t, []string{"1.0.1.0/24", "1.0.16.0/20"}, cfg.Routes)
+
+	cfg.Route.Country = "CN"
+	assert.Nil(t, cfg.loadRoutes())
+	assert.Equal(t, []string{"1.0.1.0/24"}, cfg.Routes)
+}
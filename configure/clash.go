@@ -0,0 +1,86 @@
+package configure
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+)
+
+type clashProxy struct {
+	Name     string
+	Type     string
+	Server   string
+	Port     string
+	Username string
+	Password string
+	TLS      bool `yaml:"tls"`
+}
+
+type clashProxyGroup struct {
+	Name    string
+	Type    string
+	Proxies []string
+}
+
+type clashConfig struct {
+	Proxies     []clashProxy      `yaml:"proxies"`
+	ProxyGroups []clashProxyGroup `yaml:"proxy-groups"`
+	Rules       []string          `yaml:"rules"`
+	DNS         struct {
+		Nameserver []string
+		Fallback   []string
+	}
+}
+
+// ImportClash map a clash config file onto cfg, the entries which can not be represented are returned as skipped.
+// socks5 and http proxies become [proxy] and a proxy group becomes its first supported proxy,
+// DOMAIN-SUFFIX, DOMAIN-KEYWORD, IP-CIDR and GEOIP rules become patterns and MATCH becomes rule.final.
+// DOMAIN rules become DOMAIN-SUFFIX and are reported too.
+func (cfg *AppConfig) ImportClash(filename string) ([]Skipped, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var clash clashConfig
+	if err := yaml.Unmarshal(data, &clash); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	im := newImporter()
+	for i, p := range clash.Proxies {
+		entry := fmt.Sprintf("proxies[%d] %q", i, p.Name)
+		if p.Type != "socks5" && p.Type != "http" {
+			im.skip(entry, "type %s is not supported, only socks5 and http", p.Type)
+			continue
+		}
+		if p.TLS {
+			im.skip(entry, "tls is not supported")
+			continue
+		}
+		im.addProxy(p.Name, p.Type, p.Server, p.Port, p.Username, p.Password)
+	}
+
+	groups := make(map[string][]string)
+	for _, g := range clash.ProxyGroups {
+		groups[g.Name] = g.Proxies
+	}
+	for i, g := range clash.ProxyGroups {
+		im.addGroup(fmt.Sprintf("proxy-groups[%d] %q", i, g.Name), g.Name, g.Type, groups)
+	}
+
+	for i, r := range clash.Rules {
+		im.addForeignRule(fmt.Sprintf("rules[%d] %q", i, r), r)
+	}
+	im.setDefaultProxy()
+
+	for i, ns := range clash.DNS.Nameserver {
+		im.addNameserver(fmt.Sprintf("dns.nameserver[%d] %q", i, ns), ns)
+	}
+	for i, ns := range clash.DNS.Fallback {
+		im.skip(fmt.Sprintf("dns.fallback[%d] %q", i, ns), "fallback nameservers are not supported")
+	}
+
+	*cfg = *im.cfg
+	return im.skipped, nil
+}
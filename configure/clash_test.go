@@ -0,0 +1,92 @@
+package configure
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const clashYAML = `
+port: 7890
+dns:
+  enable: true
+  nameserver:
+    - 114.114.114.114
+    - udp://8.8.8.8:5353
+    - tls://1.1.1.1:853
+  fallback:
+    - 8.8.4.4
+proxies:
+  - name: ss1
+    type: ss
+    server: 1.2.3.4
+    port: 443
+  - name: socks
+    type: socks5
+    server: 127.0.0.1
+    port: 1080
+  - name: web
+    type: http
+    server: 127.0.0.1
+    port: "8080"
+    username: u
+    password: p
+proxy-groups:
+  - name: Proxy
+    type: select
+    proxies: [ss1, socks, web]
+  - name: Ads
+    type: select
+    proxies: [REJECT]
+rules:
+  - DOMAIN-SUFFIX,google.com,Proxy
+  - DOMAIN-SUFFIX,twitter.com,Proxy
+  - DOMAIN-KEYWORD,baidu,DIRECT
+  - DOMAIN,ads.example.com,Ads
+  - DOMAIN-SUFFIX,doubleclick.net,Ads
+  - IP-CIDR,91.108.4.0/22,web,no-resolve
+  - DST-PORT,22,DIRECT
+  - GEOIP,CN,DIRECT
+  - MATCH,Proxy
+  - DOMAIN-SUFFIX,example.com,DIRECT
+`
+
+func TestImportClash(t *testing.T) {
+	filename := writeTemp(t, "clash.yaml", clashYAML)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	cfg := new(AppConfig)
+	skipped, err := cfg.ImportClash(filename)
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]*ProxyConfig{
+		"socks": {URL: "socks5://127.0.0.1:1080", Default: true},
+		"web":   {URL: "http://u:p@127.0.0.1:8080"},
+	}, cfg.Proxy)
+	assert.Equal(t, []string{"socks-domain-suffix-1", "direct-domain-keyword-2", "block-domain-suffix-3", "web-ip-cidr-4", "direct-ip-country-5"}, cfg.Rule.Pattern)
+	assert.Equal(t, &PatternConfig{Proxy: "socks", Scheme: SchemeDomainSuffix, V: []string{"google.com", "twitter.com"}}, cfg.Pattern["socks-domain-suffix-1"])
+	assert.Equal(t, &PatternConfig{Proxy: ProxyBlock, Scheme: SchemeDomainSuffix, V: []string{"ads.example.com", "doubleclick.net"}}, cfg.Pattern["block-domain-suffix-3"])
+	assert.Equal(t, &PatternConfig{Scheme: SchemeIPCountry, V: []string{"CN"}}, cfg.Pattern["direct-ip-country-5"])
+	assert.Equal(t, "socks", cfg.Rule.Final)
+	assert.Equal(t, []string{"114.114.114.114:53", "8.8.8.8:5353"}, cfg.DNS.Nameserver)
+	assert.Equal(t, "198.18.0.0/15", cfg.General.Network)
+
+	var entries []string
+	for _, s := range skipped {
+		entries = append(entries, s.Entry)
+	}
+	assert.Equal(t, []string{
+		`proxies[0] "ss1"`,
+		`proxy-groups[0] "Proxy"`,
+		`rules[3] "DOMAIN,ads.example.com,Ads"`,
+		`rules[6] "DST-PORT,22,DIRECT"`,
+		`rules[9] "DOMAIN-SUFFIX,example.com,DIRECT"`,
+		`dns.nameserver[2] "tls://1.1.1.1:853"`,
+		`dns.fallback[0] "8.8.4.4"`,
+	}, entries)
+	assert.Equal(t, `proxy-groups[0] "Proxy": select group is replaced by its first supported proxy "socks"`, skipped[1].String())
+	assert.Equal(t, "DOMAIN is imported as DOMAIN-SUFFIX, the subdomains of ads.example.com match too", skipped[2].Reason)
+	assert.Nil(t, cfg.check())
+}
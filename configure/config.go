@@ -4,6 +4,7 @@ import (
 	"errors"
 	"log"
 	"net/url"
+	"path/filepath"
 
 	"gopkg.in/gcfg.v1"
 )
@@ -19,43 +20,74 @@ const (
 
 // GeneralConfig ini
 type GeneralConfig struct {
-	Network   string // tun network
-	Mtu       uint32
-	Interface string
+	Network     string // tun network
+	Mtu         uint32
+	Interface   string
+	TunFd       int    `gcfg:"tun-fd" yaml:"tun-fd"`             // an already opened tun fd, -1 means create a new tun
+	GracePeriod int    `gcfg:"grace-period" yaml:"grace-period"` // seconds live tunnels have to finish on shutdown
+	TunName     string `gcfg:"tun-name" yaml:"tun-name"`         // empty means picked by the system
+	TunPersist  bool   `gcfg:"tun-persist" yaml:"tun-persist"`   // linux only, keep the tun after exit
+	TunOwner    string `gcfg:"tun-owner" yaml:"tun-owner"`       // linux only, user name or uid which can open the tun
+	TunGroup    string `gcfg:"tun-group" yaml:"tun-group"`       // linux only, group name or gid which can open the tun
+	TunQueues   int    `gcfg:"tun-queues" yaml:"tun-queues"`     // linux only, more than 1 means a multi-queue tun
+	// linux only, switch to User (default the sudo user) after the tun, routes and dns are set up
+	DropPrivileges bool   `gcfg:"drop-privileges" yaml:"drop-privileges"`
+	User           string `gcfg:"user" yaml:"user"`
+	StateFile      string `gcfg:"state-file" yaml:"state-file"` // SIGUSR1 writes the state of tunnels, dns and netstack to it, empty means the log
+	CacheDir       string `gcfg:"cache-dir" yaml:"cache-dir"`   // cache of the rule-set subscriptions of patterns, relative to the config file
 }
 
 // PprofConfig ini
 type PprofConfig struct {
 	Enabled  bool
-	ProfHost string `gcfg:"prof-host"`
-	ProfPort uint16 `gcfg:"prof-port"`
+	ProfHost string `gcfg:"prof-host" yaml:"prof-host"`
+	ProfPort uint16 `gcfg:"prof-port" yaml:"prof-port"`
 }
 
 // DNSConfig ini
 type DNSConfig struct {
-	DNSMode             string   `gcfg:"dns-mode"`
-	DNSPort             uint16   `gcfg:"dns-port"`
-	DNSTtl              uint     `gcfg:"dns-ttl"`
-	DNSPacketSize       uint16   `gcfg:"dns-packet-size"`
-	DNSReadTimeout      uint     `gcfg:"dns-read-timeout"`
-	DNSWriteTimeout     uint     `gcfg:"dns-write-timeout"`
-	AutoConfigSystemDNS bool     `gcfg:"auto-config-system-dns"`
-	Nameserver          []string // backend dns
+	DNSMode             string   `gcfg:"dns-mode" yaml:"dns-mode"`
+	DNSPort             uint16   `gcfg:"dns-port" yaml:"dns-port"`
+	DNSTtl              uint     `gcfg:"dns-ttl" yaml:"dns-ttl"`
+	DNSPacketSize       uint16   `gcfg:"dns-packet-size" yaml:"dns-packet-size"`
+	DNSReadTimeout      uint     `gcfg:"dns-read-timeout" yaml:"dns-read-timeout"`
+	DNSWriteTimeout     uint     `gcfg:"dns-write-timeout" yaml:"dns-write-timeout"`
+	AutoConfigSystemDNS bool     `gcfg:"auto-config-system-dns" yaml:"auto-config-system-dns"`
+	Nameserver          []string `yaml:",omitempty"` // backend dns
 	OriginNameserver    string
 }
 
 type RouteConfig struct {
-	V []string
+	V            []string `yaml:",omitempty"`
+	File         []string `yaml:",omitempty"` // route list files, plain or apnic delegated, relative to the config file
+	Exclude      []string `yaml:",omitempty"` // subnets or hosts subtracted from v and file
+	ExcludeFile  []string `gcfg:"exclude-file" yaml:"exclude-file,omitempty"`
+	Country      string   // country of the apnic delegated lines in the route list files, empty means all
+	AutoRoute    bool     `gcfg:"auto-route" yaml:"auto-route"`       // linux only, capture the default route by policy routing
+	Table        int      `gcfg:"table" yaml:"table"`                 // routing table of the default route for auto-route, uid and cgroup
+	FWMark       int      `gcfg:"fwmark" yaml:"fwmark"`               // mark of the sockets opened by tun2socks, they bypass auto-route, split route and the kill switch
+	RulePriority int      `gcfg:"rule-priority" yaml:"rule-priority"` // priority of the first policy rule, 3 priorities are used
+	KillSwitch   bool     `gcfg:"kill-switch" yaml:"kill-switch"`     // linux only, drop the output which does not go through the tun
+	// linux only, route only these uid ranges and cgroup v2 paths into the tun by policy routing, not with auto-route
+	UID       []string `gcfg:"uid" yaml:"uid,omitempty"`
+	Cgroup    []string `gcfg:"cgroup" yaml:"cgroup,omitempty"`
+	SplitMark int      `gcfg:"split-mark" yaml:"split-mark"` // mark of the packets of the cgroups
 }
 
 type PatternConfig struct {
 	Proxy  string
 	Scheme string
-	V      []string
+	V      []string `yaml:",omitempty"`
+	File   []string `yaml:",omitempty"` // rule-set files, plain, surge or clash lists, relative to the config file
+	// rule-set subscriptions, cached in general.cache-dir and fetched again every interval seconds, 0 means only when not cached
+	URL      []string `gcfg:"url" yaml:"url,omitempty"`
+	Interval int      `gcfg:"interval" yaml:"interval"`
+	dir      string   // directory of the config file, set by Parse
+	cacheDir string   // general.cache-dir, set by Parse
 }
 
 type RuleConfig struct {
-	Pattern []string
+	Pattern []string `yaml:",omitempty"`
 	Final   string
 }
 
@@ -72,6 +104,7 @@ type UDPConfig struct {
 
 type TCPConfig struct {
 	Timeout int
+	MSS     uint16 `gcfg:"mss" yaml:"mss"` // clamp the mss of syn from the tun, 0 means disabled
 }
 
 type AppConfig struct {
@@ -84,20 +117,60 @@ type AppConfig struct {
 	Proxy   map[string]*ProxyConfig
 	Pattern map[string]*PatternConfig
 	Rule    RuleConfig
-	File    string
+	File    string   `yaml:"-"`
+	Routes  []string `yaml:"-"` // route.v and route.file minus route.exclude and route.exclude-file, loaded by Parse
 }
 
-func (cfg *AppConfig) check() error {
-	// TODO
-	return nil
+// Parse the config file to AppConfig, config.ini or config.yaml, config.yml and config.json
+func (cfg *AppConfig) Parse(filename string) error {
+	err := cfg.Decode(filename)
+	if err != nil {
+		return err
+	}
+
+	// set backend dns default value
+	if len(cfg.DNS.Nameserver) == 0 {
+		cfg.DNS.Nameserver = append(cfg.DNS.Nameserver, "119.29.29.29:53")
+		cfg.DNS.Nameserver = append(cfg.DNS.Nameserver, "223.5.5.5:53")
+		cfg.DNS.Nameserver = append(cfg.DNS.Nameserver, "8.8.8.8:53")
+	}
+
+	cfg.File = filename
+	for _, p := range cfg.Pattern {
+		if p == nil {
+			// reported by check
+			continue
+		}
+		p.dir = filepath.Dir(filename)
+		p.cacheDir = cfg.Path(cfg.General.CacheDir)
+	}
+	if err := cfg.check(); err != nil {
+		return err
+	}
+	return cfg.loadRoutes()
 }
 
-// Parse the config.ini file to AppConfig
-func (cfg *AppConfig) Parse(filename string) error {
-	// set default value
+// Decode the config file over the default values, the format is picked by the extension of filename, gcfg ini by default.
+// Unlike Parse, the values are not checked and the route list files are not read.
+func (cfg *AppConfig) Decode(filename string) error {
+	cfg.setDefaults()
+
+	// decode config value
+	if FormatOf(filename) != FormatINI {
+		return cfg.decodeYAML(filename)
+	}
+	return gcfg.ReadFileInto(cfg, filename)
+}
+
+// setDefaults set the default values, which are kept when a key is missing in the config file
+func (cfg *AppConfig) setDefaults() {
 	cfg.General.Network = "198.18.0.0/15"
 	cfg.General.Mtu = 1500
 	cfg.General.Interface = ""
+	cfg.General.TunFd = -1
+	cfg.General.GracePeriod = 5
+	cfg.General.TunQueues = 1
+	cfg.General.CacheDir = "cache"
 
 	cfg.Pprof.Enabled = false
 	cfg.Pprof.ProfHost = "127.0.0.1"
@@ -114,29 +187,21 @@ func (cfg *AppConfig) Parse(filename string) error {
 
 	cfg.TCP.Timeout = 60
 
+	cfg.Route.Table = 2022
+	cfg.Route.FWMark = 2022
+	cfg.Route.RulePriority = 9000
+	cfg.Route.SplitMark = 2023
+
 	cfg.UDP.Enabled = true
 	cfg.UDP.Timeout = 300
+}
 
-	// decode config value
-	err := gcfg.ReadFileInto(cfg, filename)
-	if err != nil {
-		return err
-	}
-
-	// set backend dns default value
-	if len(cfg.DNS.Nameserver) == 0 {
-		cfg.DNS.Nameserver = append(cfg.DNS.Nameserver, "119.29.29.29:53")
-		cfg.DNS.Nameserver = append(cfg.DNS.Nameserver, "223.5.5.5:53")
-		cfg.DNS.Nameserver = append(cfg.DNS.Nameserver, "8.8.8.8:53")
-	}
-
-	err = cfg.check()
-	if err != nil {
-		return err
+// SocketMark return the mark of the sockets opened by tun2socks, 0 means no mark
+func (cfg *AppConfig) SocketMark() int {
+	if cfg.Route.AutoRoute || cfg.Route.KillSwitch || len(cfg.Route.UID) > 0 || len(cfg.Route.Cgroup) > 0 {
+		return cfg.Route.FWMark
 	}
-
-	cfg.File = filename
-	return nil
+	return 0
 }
 
 // GetProxy addr from name
@@ -0,0 +1,126 @@
+package configure
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// config file formats
+const (
+	FormatINI  = "ini"
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+)
+
+// FormatOf return the format of a config file by its extension, gcfg ini by default
+func FormatOf(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	}
+	return FormatINI
+}
+
+// decodeYAML decode a yaml or json config file, json is a subset of yaml.
+// Keys are the same as the ini, sections are maps, subsections are maps of maps and multi-valued keys are lists.
+func (cfg *AppConfig) decodeYAML(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	// unknown keys are errors like gcfg
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return cfg.checkEmpty()
+}
+
+// WriteYAML write the config as yaml, every key is written so the defaults of Parse do not change it
+func (cfg *AppConfig) WriteYAML(w io.Writer) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// WriteINI write the config as gcfg ini, every key is written so the defaults of Parse do not change it
+func (cfg *AppConfig) WriteINI(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := keyName(f)
+		if name == "-" {
+			continue
+		}
+		section := v.Field(i)
+		switch section.Kind() {
+		case reflect.Struct:
+			fmt.Fprintf(buf, "[%s]\n", name)
+			writeINIKeys(buf, section)
+			buf.WriteString("\n")
+		case reflect.Map:
+			keys := section.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+			for _, k := range keys {
+				fmt.Fprintf(buf, "[%s %s]\n", name, quoteINI(k.String(), true))
+				writeINIKeys(buf, section.MapIndex(k).Elem())
+				buf.WriteString("\n")
+			}
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeINIKeys write the fields of a section, a list is written as repeated keys
+func writeINIKeys(buf *bytes.Buffer, section reflect.Value) {
+	t := section.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := keyName(t.Field(i))
+		if name == "-" || t.Field(i).PkgPath != "" {
+			continue
+		}
+		field := section.Field(i)
+		switch field.Kind() {
+		case reflect.Slice:
+			for j := 0; j < field.Len(); j++ {
+				fmt.Fprintf(buf, "%s = %s\n", name, quoteINI(field.Index(j).String(), false))
+			}
+		case reflect.String:
+			fmt.Fprintf(buf, "%s = %s\n", name, quoteINI(field.String(), false))
+		default:
+			fmt.Fprintf(buf, "%s = %v\n", name, field.Interface())
+		}
+	}
+}
+
+// keyName return the key of a field, the yaml tag is the same as the gcfg tag, otherwise the lower case field name
+func keyName(f reflect.StructField) string {
+	if tag := strings.Split(f.Tag.Get("yaml"), ",")[0]; tag != "" {
+		return tag
+	}
+	return strings.ToLower(f.Name)
+}
+
+// quoteINI quote s when gcfg would not read it back as is, subsection names are always quoted
+func quoteINI(s string, always bool) string {
+	if !always && s != "" && s == strings.TrimSpace(s) && !strings.ContainsAny(s, ";#\"\\\n\t") {
+		return s
+	}
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n", "\t", "\\t")
+	return "\"" + r.Replace(s) + "\""
+}
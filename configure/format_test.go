@@ -0,0 +1,155 @@
+package configure
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTemp(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "tun2socks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestFormatOf(t *testing.T) {
+	assert.Equal(t, FormatINI, FormatOf("config.ini"))
+	assert.Equal(t, FormatINI, FormatOf("config"))
+	assert.Equal(t, FormatYAML, FormatOf("config.yaml"))
+	assert.Equal(t, FormatYAML, FormatOf("/etc/tun2socks/config.YML"))
+	assert.Equal(t, FormatJSON, FormatOf("config.json"))
+}
+
+func TestDecodeYAML(t *testing.T) {
+	filename := writeTemp(t, "config.yaml", `
+general:
+  mtu: 1400
+dns:
+  dns-mode: udp_relay_via_socks5
+udp:
+  enabled: false
+proxy:
+  A:
+    url: socks5://127.0.0.1:1080
+    default: true
+pattern:
+  proxy-domain:
+    proxy: A
+    scheme: DOMAIN-SUFFIX
+    v: [google.com, twitter.com]
+rule:
+  pattern: [proxy-domain]
+  final: A
+`)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	cfg := new(AppConfig)
+	assert.Nil(t, cfg.Decode(filename))
+	assert.Equal(t, uint32(1400), cfg.General.Mtu)
+	assert.Equal(t, "198.18.0.0/15", cfg.General.Network)
+	assert.Equal(t, "udp_relay_via_socks5", cfg.DNS.DNSMode)
+	assert.Equal(t, uint16(DNSDefaultPort), cfg.DNS.DNSPort)
+	assert.False(t, cfg.UDP.Enabled)
+	assert.Equal(t, 300, cfg.UDP.Timeout)
+	assert.Equal(t, &ProxyConfig{URL: "socks5://127.0.0.1:1080", Default: true}, cfg.Proxy["A"])
+	assert.Equal(t, []string{"google.com", "twitter.com"}, cfg.Pattern["proxy-domain"].V)
+	assert.Equal(t, []string{"proxy-domain"}, cfg.Rule.Pattern)
+
+	filename = writeTemp(t, "config.yaml", "general:\n  mtuu: 1400\n")
+	defer os.RemoveAll(filepath.Dir(filename))
+	assert.NotNil(t, new(AppConfig).Decode(filename))
+}
+
+func TestDecodeJSON(t *testing.T) {
+	filename := writeTemp(t, "config.json", "{\n\t\"general\": {\"tun-queues\": 2},\n\t\"route\": {\"v\": [\"91.108.4.0/22\"], \"auto-route\": true}\n}\n")
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	cfg := new(AppConfig)
+	assert.Nil(t, cfg.Decode(filename))
+	assert.Equal(t, 2, cfg.General.TunQueues)
+	assert.Equal(t, []string{"91.108.4.0/22"}, cfg.Route.V)
+	assert.True(t, cfg.Route.AutoRoute)
+	assert.Equal(t, 2022, cfg.Route.Table)
+}
+
+func TestWriteYAML(t *testing.T) {
+	cfg := validConfig()
+	cfg.UDP.Enabled = false
+	cfg.Route.ExcludeFile = []string{"private.txt"}
+	cfg.File = "config.ini"
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, cfg.WriteYAML(buf))
+	assert.Contains(t, buf.String(), "exclude-file:\n  - private.txt\n")
+	assert.NotContains(t, buf.String(), "config.ini")
+
+	filename := writeTemp(t, "config.yml", buf.String())
+	defer os.RemoveAll(filepath.Dir(filename))
+	decoded := new(AppConfig)
+	assert.Nil(t, decoded.Decode(filename))
+	cfg.File = ""
+	assert.Equal(t, cfg, decoded)
+}
+
+func TestWriteINI(t *testing.T) {
+	cfg := new(AppConfig)
+	cfg.DNS.Nameserver = []string{"8.8.8.8:53", "1.1.1.1:53"}
+	cfg.DNS.DNSMode = "fake"
+	cfg.Proxy = map[string]*ProxyConfig{"B": {URL: "socks5://127.0.0.1:1080"}, "A": {URL: "http://a;b"}}
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, cfg.WriteINI(buf))
+	ini := buf.String()
+	assert.Contains(t, ini, "[dns]\ndns-mode = fake\ndns-port = 0\n")
+	assert.Contains(t, ini, "nameserver = 8.8.8.8:53\nnameserver = 1.1.1.1:53\noriginnameserver = \"\"\n")
+	assert.Contains(t, ini, "[route]\ncountry = \"\"\nauto-route = false\n")
+	assert.Contains(t, ini, "[proxy \"A\"]\nurl = \"http://a;b\"\ndefault = false\n\n[proxy \"B\"]\n")
+	assert.NotContains(t, ini, "[file]")
+}
+
+func TestPatternPath(t *testing.T) {
+	filename := writeTemp(t, "config.yaml", `
+proxy:
+  A:
+    url: socks5://127.0.0.1:1080
+pattern:
+  proxy-domain:
+    proxy: A
+    scheme: DOMAIN-SUFFIX
+    file: [rules/proxy.list, /etc/tun2socks/proxy.list]
+`)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	cfg := new(AppConfig)
+	assert.Nil(t, cfg.Parse(filename))
+	p := cfg.Pattern["proxy-domain"]
+	assert.Equal(t, filepath.Join(filepath.Dir(filename), "rules/proxy.list"), p.Path(p.File[0]))
+	assert.Equal(t, "/etc/tun2socks/proxy.list", p.Path(p.File[1]))
+}
+
+func TestDecodeEmptyEntry(t *testing.T) {
+	filename := writeTemp(t, "config.yaml", "proxy:\n  A:\npattern:\n  p:\n")
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	err := new(AppConfig).Parse(filename)
+	assert.EqualError(t, err, "[proxy \"A\"] url: empty proxy, url is required\n[pattern \"p\"] scheme: empty pattern, scheme is required")
+
+	cfg := validConfig()
+	cfg.Proxy["B"] = nil
+	cfg.Pattern["q"] = nil
+	errs, ok := cfg.check().(CheckError)
+	if assert.True(t, ok) {
+		assert.Equal(t, `[proxy "B"] url: empty proxy, url is required`, errs[0].Error())
+		assert.Equal(t, `[pattern "q"] scheme: empty pattern, scheme is required`, errs[1].Error())
+	}
+}
@@ -0,0 +1,237 @@
+package configure
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Skipped is an entry of a foreign config which can not be represented by AppConfig
+type Skipped struct {
+	Entry  string // eg: rules[3] "DST-PORT,22,DIRECT"
+	Reason string
+}
+
+func (s Skipped) String() string {
+	return s.Entry + ": " + s.Reason
+}
+
+// importer build an AppConfig from the proxies and rules of a foreign config, in the order of the rules
+type importer struct {
+	cfg     *AppConfig
+	skipped []Skipped
+	first   string            // the first proxy
+	groups  map[string]string // proxy groups are replaced by one of their proxies
+	last    *PatternConfig    // consecutive rules of the same scheme and proxy share a pattern
+	final   bool
+}
+
+func newImporter() *importer {
+	cfg := new(AppConfig)
+	cfg.setDefaults()
+	cfg.Proxy = make(map[string]*ProxyConfig)
+	cfg.Pattern = make(map[string]*PatternConfig)
+	return &importer{cfg: cfg, groups: make(map[string]string)}
+}
+
+func (im *importer) skip(entry, format string, a ...interface{}) {
+	im.skipped = append(im.skipped, Skipped{Entry: entry, Reason: fmt.Sprintf(format, a...)})
+}
+
+// addProxy add a socks5 or http proxy
+func (im *importer) addProxy(name, scheme, server, port, username, password string) {
+	u := &url.URL{Scheme: scheme, Host: net.JoinHostPort(server, port)}
+	if username != "" {
+		u.User = url.UserPassword(username, password)
+	}
+	im.cfg.Proxy[name] = &ProxyConfig{URL: u.String()}
+	if im.first == "" {
+		im.first = name
+	}
+}
+
+// addGroup replace the group name by its first supported proxy, the first one is the default of a select group.
+// groups are the proxies of all the groups, a group may be a proxy of another group.
+func (im *importer) addGroup(entry, name, typ string, groups map[string][]string) {
+	proxy, err := im.resolveGroup(name, groups, make(map[string]bool))
+	if err != nil {
+		im.skip(entry, "%v", err)
+		return
+	}
+	im.groups[name] = proxy
+	if len(groups[name]) > 1 || typ != "select" {
+		if proxy == "" {
+			proxy = "DIRECT"
+		}
+		im.skip(entry, "%s group is replaced by its first supported proxy %q", typ, proxy)
+	}
+}
+
+// resolveGroup follow the first supported proxy of groups, seen detect the loops of groups
+func (im *importer) resolveGroup(name string, groups map[string][]string, seen map[string]bool) (string, error) {
+	proxies, ok := groups[name]
+	if !ok {
+		return im.policy(name)
+	}
+	if seen[name] {
+		return "", fmt.Errorf("loop of group %q", name)
+	}
+	seen[name] = true
+	defer delete(seen, name)
+	for _, p := range proxies {
+		if proxy, err := im.resolveGroup(p, groups, seen); err == nil {
+			return proxy, nil
+		}
+	}
+	return "", fmt.Errorf("no supported proxy in group %q", name)
+}
+
+// policy map a policy of a rule to a proxy, empty means direct
+func (im *importer) policy(policy string) (string, error) {
+	switch strings.ToUpper(policy) {
+	case "DIRECT":
+		return "", nil
+	case "REJECT", "REJECT-DROP", "REJECT-TINYGIF":
+		return ProxyBlock, nil
+	}
+	if im.cfg.Proxy[policy] != nil {
+		return policy, nil
+	}
+	if proxy, ok := im.groups[policy]; ok {
+		return proxy, nil
+	}
+	return "", fmt.Errorf("policy %q is skipped or unknown", policy)
+}
+
+// foreignRule is a clash or surge rule, eg: DOMAIN-SUFFIX,google.com,Proxy or MATCH,Proxy
+type foreignRule struct {
+	Type    string // upper case, eg: DOMAIN-SUFFIX
+	Value   string // empty for MATCH and FINAL
+	Policy  string
+	Options []string // after the policy, eg: no-resolve
+}
+
+var errNoPolicy = errors.New("no policy")
+
+// parseForeignRule split a clash or surge rule, the type is returned even if the policy is missing
+func parseForeignRule(rule string) (foreignRule, error) {
+	parts := strings.Split(rule, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	r := foreignRule{Type: strings.ToUpper(parts[0])}
+	if r.Type == "MATCH" || r.Type == "FINAL" {
+		if len(parts) < 2 {
+			return r, errNoPolicy
+		}
+		r.Policy, r.Options = parts[1], parts[2:]
+		return r, nil
+	}
+	if len(parts) < 3 {
+		return r, errNoPolicy
+	}
+	r.Value, r.Policy, r.Options = parts[1], parts[2], parts[3:]
+	return r, nil
+}
+
+// addForeignRule add a clash or surge rule, a DOMAIN rule becomes DOMAIN-SUFFIX as there is no exact domain pattern
+func (im *importer) addForeignRule(entry, rule string) {
+	if im.final {
+		im.skip(entry, "after the final rule, never matched")
+		return
+	}
+	r, err := parseForeignRule(rule)
+	if r.Type == "MATCH" || r.Type == "FINAL" {
+		if err != nil {
+			im.skip(entry, "%v", err)
+			return
+		}
+		proxy, err := im.policy(r.Policy)
+		if err != nil {
+			im.skip(entry, "%v", err)
+			return
+		}
+		im.cfg.Rule.Final = proxy
+		im.final = true
+		return
+	}
+
+	var scheme string
+	switch r.Type {
+	case "DOMAIN", "DOMAIN-SUFFIX":
+		scheme = SchemeDomainSuffix
+	case "DOMAIN-KEYWORD":
+		scheme = SchemeDomainKeyword
+	case "IP-CIDR":
+		scheme = SchemeIPCIDR
+	case "GEOIP":
+		scheme = SchemeIPCountry
+	default:
+		im.skip(entry, "rule type %s is not supported", r.Type)
+		return
+	}
+	if err != nil {
+		im.skip(entry, "%v", err)
+		return
+	}
+	if scheme == SchemeIPCIDR {
+		if _, _, err := net.ParseCIDR(r.Value); err != nil {
+			im.skip(entry, "%q is not a cidr", r.Value)
+			return
+		}
+	}
+	proxy, err := im.policy(r.Policy)
+	if err != nil {
+		im.skip(entry, "%v", err)
+		return
+	}
+	im.addRule(scheme, r.Value, proxy)
+	if r.Type == "DOMAIN" {
+		im.skip(entry, "DOMAIN is imported as DOMAIN-SUFFIX, the subdomains of %s match too", r.Value)
+	}
+}
+
+// addNameserver add an udp nameserver, the port is 53 by default
+func (im *importer) addNameserver(entry, ns string) {
+	ns = strings.TrimPrefix(ns, "udp://")
+	if strings.Contains(ns, "://") {
+		im.skip(entry, "only udp nameservers are supported")
+		return
+	}
+	host, port, err := net.SplitHostPort(ns)
+	if err != nil {
+		host, port = ns, "53"
+	}
+	if net.ParseIP(host) == nil {
+		im.skip(entry, "%q is not an ip", host)
+		return
+	}
+	im.cfg.DNS.Nameserver = append(im.cfg.DNS.Nameserver, net.JoinHostPort(host, port))
+}
+
+// addRule append v to the pattern of the previous rule or a new pattern, proxy is empty for direct
+func (im *importer) addRule(scheme, v, proxy string) {
+	if im.last != nil && im.last.Scheme == scheme && im.last.Proxy == proxy {
+		im.last.V = append(im.last.V, v)
+		return
+	}
+	policy := proxy
+	if policy == "" {
+		policy = "direct"
+	}
+	name := fmt.Sprintf("%s-%s-%d", policy, strings.ToLower(scheme), len(im.cfg.Rule.Pattern)+1)
+	im.last = &PatternConfig{Proxy: proxy, Scheme: scheme, V: []string{v}}
+	im.cfg.Pattern[name] = im.last
+	im.cfg.Rule.Pattern = append(im.cfg.Rule.Pattern, name)
+}
+
+// setDefaultProxy make the final proxy or the first proxy the default
+func (im *importer) setDefaultProxy() {
+	if p := im.cfg.Proxy[im.cfg.Rule.Final]; p != nil {
+		p.Default = true
+	} else if p := im.cfg.Proxy[im.first]; p != nil {
+		p.Default = true
+	}
+}
@@ -0,0 +1,18 @@
+package configure
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"path/filepath"
+)
+
+// Path resolve a rule-set file name relative to the directory of the config file
+func (p *PatternConfig) Path(name string) string {
+	return resolvePath(p.dir, name)
+}
+
+// CacheFile return the file which caches the rule-set of url in general.cache-dir
+func (p *PatternConfig) CacheFile(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(p.cacheDir, hex.EncodeToString(sum[:])+".list")
+}
@@ -1,12 +1,19 @@
 package configure
 
 import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/FlowerWrong/proxy"
+	netproxy "golang.org/x/net/proxy"
 )
 
 var errNoProxy = errors.New("no proxy")
@@ -14,6 +21,7 @@ var errNoProxy = errors.New("no proxy")
 // Proxies struct
 type Proxies struct {
 	proxies map[string]*proxy.Proxy
+	urls    map[string]*url.URL
 	Default string
 }
 
@@ -39,36 +47,149 @@ func (p *Proxies) DefaultDial(addr string) (net.Conn, error) {
 	return dialer.Dial("tcp", addr)
 }
 
+// DialWith dial addr by the proxy name like Dial, but the proxy server is connected by forward, eg: a dialer marking its sockets.
+// socks5 and http(s) proxies are supported, the user and password of the url are kept.
+func (p *Proxies) DialWith(forward *net.Dialer, name string, addr string) (net.Conn, error) {
+	if name == "" {
+		name = p.Default
+	}
+	u := p.urls[name]
+	if u == nil {
+		if name == p.Default {
+			return nil, errNoProxy
+		}
+		return nil, fmt.Errorf("invalid proxy: %s", name)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		dialer, err := netproxy.FromURL(u, forward)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.Dial("tcp", addr)
+	case "http", "https":
+		return dialConnect(forward, u, addr)
+	}
+	return nil, fmt.Errorf("proxy %q: unsupported scheme %q", name, u.Scheme)
+}
+
+// dialConnect tunnel addr by the CONNECT method of the http proxy u, which is connected by forward
+func dialConnect(forward *net.Dialer, u *url.URL, addr string) (net.Conn, error) {
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := forward.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+	}
+	if forward.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(forward.Timeout))
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("http proxy %s connect %s failed: %s", u.Host, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn read the bytes the proxy sent right after its CONNECT response first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
 // Reload config
 func (p *Proxies) Reload(config map[string]*ProxyConfig) error {
+	swap, err := p.Prepare(config)
+	if err != nil {
+		return err
+	}
+	swap()
 	log.Println("Proxies hot reloaded")
-	return p.setUp(config)
+	return nil
+}
+
+// Prepare create the proxies of config without swapping them in, swap installs them
+func (p *Proxies) Prepare(config map[string]*ProxyConfig) (swap func(), err error) {
+	proxies, urls, defaultName, err := buildProxies(config)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		p.proxies = proxies
+		p.urls = urls
+		p.Default = defaultName
+		log.Printf("[proxies] default proxy: %q", p.Default)
+	}, nil
 }
 
-func (p *Proxies) setUp(config map[string]*ProxyConfig) error {
+func buildProxies(config map[string]*ProxyConfig) (map[string]*proxy.Proxy, map[string]*url.URL, string, error) {
 	proxies := make(map[string]*proxy.Proxy)
+	urls := make(map[string]*url.URL)
+	defaultName := ""
 	for name, item := range config {
 		setupProxy, err := proxy.FromUrl(item.URL)
 		if err != nil {
-			return err
+			return nil, nil, "", fmt.Errorf("proxy %q url %q: %v", name, item.URL, err)
+		}
+		u, err := url.Parse(item.URL)
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("proxy %q url %q: %v", name, item.URL, err)
 		}
 
-		if item.Default || p.Default == "" {
-			p.Default = name
+		if item.Default || defaultName == "" {
+			defaultName = name
 		}
 		proxies[name] = setupProxy
+		urls[name] = u
 	}
-	p.proxies = proxies
-	log.Printf("[proxies] default proxy: %q", p.Default)
-	return nil
+	return proxies, urls, defaultName, nil
 }
 
 // NewProxies crate a new proxies
 func NewProxies(config map[string]*ProxyConfig) (*Proxies, error) {
 	p := &Proxies{}
-	err := p.setUp(config)
+	swap, err := p.Prepare(config)
 	if err != nil {
 		return nil, err
 	}
+	swap()
 	return p, nil
 }
@@ -0,0 +1,70 @@
+package configure
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveConnect accept one CONNECT of l, answer it by status and echo the tunnel
+func serveConnect(l net.Listener, status int) <-chan *http.Request {
+	requests := make(chan *http.Request, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		requests <- req
+		resp := &http.Response{StatusCode: status, ProtoMajor: 1, ProtoMinor: 1}
+		resp.Write(conn)
+		buf := make([]byte, 4)
+		if n, err := br.Read(buf); err == nil {
+			conn.Write(buf[:n])
+		}
+	}()
+	return requests
+}
+
+func TestDialWithHTTPProxy(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer l.Close()
+
+	p, err := NewProxies(map[string]*ProxyConfig{"A": {URL: "http://user:pass@" + l.Addr().String()}})
+	require.Nil(t, err)
+	forward := &net.Dialer{Timeout: time.Second}
+
+	requests := serveConnect(l, http.StatusOK)
+	conn, err := p.DialWith(forward, "", "example.com:443")
+	require.Nil(t, err)
+	defer conn.Close()
+	req := <-requests
+	assert.Equal(t, http.MethodConnect, req.Method)
+	assert.Equal(t, "example.com:443", req.Host)
+	assert.Equal(t, "Basic dXNlcjpwYXNz", req.Header.Get("Proxy-Authorization"))
+
+	_, err = conn.Write([]byte("ping"))
+	require.Nil(t, err)
+	buf := make([]byte, 4)
+	_, err = conn.Read(buf)
+	require.Nil(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	serveConnect(l, http.StatusProxyAuthRequired)
+	_, err = p.DialWith(forward, "A", "example.com:443")
+	assert.Contains(t, err.Error(), "407")
+
+	_, err = p.DialWith(forward, "B", "example.com:443")
+	assert.EqualError(t, err, "invalid proxy: B")
+}
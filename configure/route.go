@@ -0,0 +1,71 @@
+package configure
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/FlowerWrong/tun2socks/util"
+)
+
+// Path resolve name relative to the directory of the config file
+func (cfg *AppConfig) Path(name string) string {
+	if cfg.File == "" {
+		return name
+	}
+	return resolvePath(filepath.Dir(cfg.File), name)
+}
+
+func resolvePath(dir, name string) string {
+	if name == "" || filepath.IsAbs(name) || dir == "" {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// loadRoutes expand route.v and route.file, then subtract route.exclude and route.exclude-file, into cfg.Routes
+func (cfg *AppConfig) loadRoutes() error {
+	include, err := cfg.readRoutes("v", cfg.Route.V, "file", cfg.Route.File)
+	if err != nil {
+		return err
+	}
+	exclude, err := cfg.readRoutes("exclude", cfg.Route.Exclude, "exclude-file", cfg.Route.ExcludeFile)
+	if err != nil {
+		return err
+	}
+	if len(exclude) > 0 {
+		include = util.SubtractRoutes(include, exclude)
+	}
+
+	cfg.Routes = make([]string, 0, len(include))
+	for _, route := range include {
+		cfg.Routes = append(cfg.Routes, route.String())
+	}
+	return nil
+}
+
+// readRoutes parse routes and read the route list files, errors are a *FieldError of the route section
+func (cfg *AppConfig) readRoutes(routesKey string, routes []string, filesKey string, files []string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, r := range routes {
+		route, err := util.ParseRoute(r)
+		if err != nil {
+			return nil, &FieldError{Section: "route", Key: routesKey, Err: err}
+		}
+		result = append(result, route)
+	}
+	for _, name := range files {
+		f, err := os.Open(cfg.Path(name))
+		if err != nil {
+			return nil, &FieldError{Section: "route", Key: filesKey, Err: err}
+		}
+		list, err := util.ReadRouteList(f, cfg.Route.Country)
+		f.Close()
+		if err != nil {
+			return nil, &FieldError{Section: "route", Key: filesKey, Err: fmt.Errorf("%s: %v", cfg.Path(name), err)}
+		}
+		result = append(result, list...)
+	}
+	return result, nil
+}
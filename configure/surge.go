@@ -0,0 +1,175 @@
+package configure
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// surgeLine is a line of a section of surge.conf
+type surgeLine struct {
+	entry string // eg: line 12 "FINAL,Proxy"
+	key   string // empty for the lines of [Rule] and the lines which are not key = value
+	value string
+}
+
+// surgeSection is a section of surge.conf, the lines before the first section are in a section without entry
+type surgeSection struct {
+	name  string
+	entry string // eg: line 25 "[MITM]"
+	lines []surgeLine
+}
+
+// readSurge read the sections of a surge config, blank lines and comments are dropped
+func readSurge(filename string) ([]*surgeSection, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	section := &surgeSection{}
+	sections := []*surgeSection{section}
+	n := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		entry := fmt.Sprintf("line %d %q", n, line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = &surgeSection{name: strings.TrimSpace(line[1 : len(line)-1]), entry: entry}
+			sections = append(sections, section)
+			continue
+		}
+		l := surgeLine{entry: entry, value: line}
+		if section.name != "Rule" {
+			if i := strings.Index(line, "="); i > 0 {
+				l.key, l.value = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+			}
+		}
+		section.lines = append(section.lines, l)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return sections, nil
+}
+
+// ImportSurge map a surge config file onto cfg, the entries which can not be represented are returned as skipped.
+// socks5 and http proxies of [Proxy] become [proxy] and a [Proxy Group] becomes its first supported proxy,
+// DOMAIN-SUFFIX, DOMAIN-KEYWORD, IP-CIDR and GEOIP rules of [Rule] become patterns and FINAL becomes rule.final,
+// DIRECT is direct and REJECT is block. DOMAIN rules become DOMAIN-SUFFIX and are reported too.
+// dns-server of [General] becomes dns.nameserver.
+func (cfg *AppConfig) ImportSurge(filename string) ([]Skipped, error) {
+	all, err := readSurge(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	im := newImporter()
+	sections := make(map[string][]surgeLine)
+	for _, section := range all {
+		switch section.name {
+		case "General", "Proxy", "Proxy Group", "Rule":
+		default:
+			if section.entry == "" {
+				for _, l := range section.lines {
+					im.skip(l.entry, "not in a section")
+				}
+			} else {
+				im.skip(section.entry, "section is not supported")
+			}
+			continue
+		}
+		for _, l := range section.lines {
+			if section.name != "Rule" && l.key == "" {
+				im.skip(l.entry, "not a key = value line")
+				continue
+			}
+			sections[section.name] = append(sections[section.name], l)
+		}
+	}
+
+	for _, l := range sections["Proxy"] {
+		args := splitSurgeArgs(l.value)
+		switch typ := strings.ToLower(args[0]); typ {
+		case "direct":
+			im.groups[l.key] = ""
+		case "reject", "reject-tinygif":
+			im.groups[l.key] = ProxyBlock
+		case "socks5", "http":
+			if len(args) < 3 {
+				im.skip(l.entry, "no server and port")
+				continue
+			}
+			var positional []string
+			var username, password string
+			for _, arg := range args[3:] {
+				if i := strings.Index(arg, "="); i >= 0 {
+					switch k, v := strings.TrimSpace(arg[:i]), strings.TrimSpace(arg[i+1:]); k {
+					case "username":
+						username = v
+					case "password":
+						password = v
+					}
+					continue
+				}
+				positional = append(positional, arg)
+			}
+			if len(positional) >= 2 {
+				username, password = positional[0], positional[1]
+			}
+			im.addProxy(l.key, typ, args[1], args[2], username, password)
+		default:
+			im.skip(l.entry, "type %s is not supported, only socks5 and http", args[0])
+		}
+	}
+
+	groups := make(map[string][]string)
+	for _, l := range sections["Proxy Group"] {
+		var proxies []string
+		for _, arg := range splitSurgeArgs(l.value)[1:] {
+			if !strings.Contains(arg, "=") {
+				proxies = append(proxies, arg)
+			}
+		}
+		groups[l.key] = proxies
+	}
+	for _, l := range sections["Proxy Group"] {
+		im.addGroup(l.entry, l.key, splitSurgeArgs(l.value)[0], groups)
+	}
+
+	for _, l := range sections["Rule"] {
+		im.addForeignRule(l.entry, l.value)
+	}
+	im.setDefaultProxy()
+
+	for _, l := range sections["General"] {
+		if l.key != "dns-server" {
+			continue
+		}
+		for _, ns := range splitSurgeArgs(l.value) {
+			if ns == "system" {
+				// the nameservers of the system are the default nameservers of Parse
+				continue
+			}
+			im.addNameserver(l.entry, ns)
+		}
+	}
+
+	*cfg = *im.cfg
+	return im.skipped, nil
+}
+
+// splitSurgeArgs split the comma separated arguments of a surge value, there is at least one argument
+func splitSurgeArgs(value string) []string {
+	args := strings.Split(value, ",")
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return args
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -13,6 +14,7 @@ import (
 	"github.com/FlowerWrong/go-hostsfile"
 	"github.com/FlowerWrong/proxy"
 	"github.com/FlowerWrong/tun2socks/configure"
+	"github.com/FlowerWrong/tun2socks/util"
 	"github.com/miekg/dns"
 	"github.com/miekg/dns/dnsutil"
 )
@@ -22,7 +24,9 @@ var errResolve = errors.New("resolve error")
 // DNS struct
 type DNS struct {
 	Server      *dns.Server
+	mu          sync.Mutex // guard client and mark, which are replaced by SetMark
 	client      *dns.Client
+	mark        int
 	nameservers []string
 	RulePtr     *Rule
 	DNSTablePtr *DNSTable
@@ -40,11 +44,14 @@ func (d *DNS) resolve(r *dns.Msg) (*dns.Msg, error) {
 	msgCh := make(chan *dns.Msg, 1)
 
 	qname := r.Question[0].Name
+	d.mu.Lock()
+	client := d.client
+	d.mu.Unlock()
 
 	Q := func(ns string) {
 		defer wg.Done()
 
-		r, _, err := d.client.Exchange(r, ns)
+		r, _, err := client.Exchange(r, ns)
 		if err != nil {
 			if e, ok := err.(net.Error); ok && e.Timeout() {
 				// This was a timeout
@@ -207,6 +214,36 @@ func (d *DNS) Serve() error {
 	return d.Server.ListenAndServe()
 }
 
+// SetMark mark the sockets to the nameservers and of the rule-set subscriptions, 0 means no mark.
+// The marked queries bypass auto-route and split route, so they do not loop back into the tun.
+func (d *DNS) SetMark(mark int) {
+	d.mu.Lock()
+	old := d.client
+	d.client = &dns.Client{
+		Net:          old.Net,
+		UDPSize:      old.UDPSize,
+		ReadTimeout:  old.ReadTimeout,
+		WriteTimeout: old.WriteTimeout,
+	}
+	if mark != 0 {
+		d.client.Dialer = util.Dialer(old.ReadTimeout, mark)
+	}
+	d.mark = mark
+	d.mu.Unlock()
+
+	d.RulePtr.SetClient(&http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{DialContext: util.Dialer(30*time.Second, mark).DialContext},
+	})
+}
+
+// Mark of the sockets to the nameservers, 0 means no mark
+func (d *DNS) Mark() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mark
+}
+
 // NewFakeDNSServer create a fake dns srever with config
 func NewFakeDNSServer(cfg *configure.AppConfig) (*DNS, error) {
 	d := new(DNS)
@@ -233,7 +270,12 @@ func NewFakeDNSServer(cfg *configure.AppConfig) (*DNS, error) {
 
 	var ip, subnet, _ = net.ParseCIDR(cfg.General.Network)
 	// new RulePtr
-	d.RulePtr = NewRule(cfg.Rule, cfg.Pattern)
+	rule, err := NewRule(cfg.Rule, cfg.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	d.RulePtr = rule
+	d.SetMark(cfg.SocketMark())
 
 	// new dns cache
 	d.DNSTablePtr = NewDnsTable(ip, subnet)
@@ -23,6 +23,17 @@ func (pool *DNSIPPool) Capacity() int {
 	return int(pool.space)
 }
 
+// Used is the number of allocated ips, including the one of tun
+func (pool *DNSIPPool) Used() int {
+	n := 0
+	for _, used := range pool.flags {
+		if used {
+			n++
+		}
+	}
+	return n
+}
+
 // Contains check a ip is in or not in dns ip pool
 func (pool *DNSIPPool) Contains(ip net.IP) bool {
 	index := util.ConvertIPv4ToUint32(ip) - pool.base
@@ -18,3 +18,10 @@ func TestDnsIPPool_Alloc(t *testing.T) {
 	dip := dnsIPPool.Alloc("lipuwater.com")
 	assert.Equal(t, "10.192.43.74", dip.String(), "they should be equal")
 }
+
+func TestDnsIPPool_Used(t *testing.T) {
+	pool := NewDNSIPPool(ip, subnet)
+	assert.Equal(t, 1, pool.Used(), "the ip of tun is used")
+	pool.Alloc("example.com")
+	assert.Equal(t, 2, pool.Used())
+}
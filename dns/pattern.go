@@ -2,6 +2,7 @@
 package dns
 
 import (
+	"fmt"
 	"net"
 	"sort"
 	"strings"
@@ -12,10 +13,10 @@ import (
 )
 
 const (
-	schemeDomainSuffix  = "DOMAIN-SUFFIX"
-	schemeDomainKeyword = "DOMAIN-KEYWORD"
-	schemeIPCountry     = "IP-COUNTRY"
-	schemeIPCIDR        = "IP-CIDR"
+	schemeDomainSuffix  = configure.SchemeDomainSuffix
+	schemeDomainKeyword = configure.SchemeDomainKeyword
+	schemeIPCountry     = configure.SchemeIPCountry
+	schemeIPCIDR        = configure.SchemeIPCIDR
 )
 
 // Pattern interface
@@ -253,9 +254,15 @@ func IsExistPatternScheme(scheme string) bool {
 	return ok
 }
 
-func CreatePattern(name string, config *configure.PatternConfig) Pattern {
-	if f := patternSchemes[config.Scheme]; f != nil {
-		return f(name, config.Proxy, config.V)
+// CreatePattern create a pattern of the values and the rule-set files of config, nil if the scheme is unknown
+func CreatePattern(name string, config *configure.PatternConfig) (Pattern, error) {
+	f := patternSchemes[config.Scheme]
+	if f == nil {
+		return nil, nil
 	}
-	return nil
+	vals, err := readPatternFiles(config)
+	if err != nil {
+		return nil, fmt.Errorf("pattern %q: %v", name, err)
+	}
+	return f(name, config.Proxy, append(append([]string(nil), config.V...), vals...)), nil
 }
@@ -2,53 +2,182 @@
 package dns
 
 import (
+	"context"
 	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/FlowerWrong/tun2socks/configure"
 )
 
 type Rule struct {
+	state atomic.Value // *ruleState, swapped by Reload and the refresh of subscriptions
+
+	mu       sync.Mutex // serialize the swaps
+	config   configure.RuleConfig
+	patterns map[string]*configure.PatternConfig
+	client   *http.Client // fetch the rule-set subscriptions, http.DefaultClient if nil
+}
+
+type ruleState struct {
 	patterns []Pattern
 	final    string
 }
 
+func (rule *Rule) load() *ruleState {
+	return rule.state.Load().(*ruleState)
+}
+
 func (rule *Rule) DirectDomain(domain string) {
-	pattern := rule.patterns[0].(*DomainSuffixPattern)
+	pattern := rule.load().patterns[0].(*DomainSuffixPattern)
 	pattern.AddDomain(domain)
 }
 
 // Proxy match a proxy for target `val`
 func (rule *Rule) Proxy(val interface{}) (bool, string) {
-	for _, pattern := range rule.patterns {
+	state := rule.load()
+	for _, pattern := range state.patterns {
 		if pattern.Match(val) {
 			return true, pattern.Proxy()
 		}
 	}
-	return false, rule.final
+	return false, state.final
+}
+
+// Reload rule config, the running patterns are kept if a rule-set file is invalid
+func (rule *Rule) Reload(config configure.RuleConfig, patterns map[string]*configure.PatternConfig) error {
+	swap, err := rule.Prepare(config, patterns)
+	if err != nil {
+		return err
+	}
+	swap()
+	return nil
+}
+
+// Prepare create the patterns of config without swapping them in, swap installs them.
+// The caller can load the rest of a reload first, and keep the running rule if it fails.
+func (rule *Rule) Prepare(config configure.RuleConfig, patterns map[string]*configure.PatternConfig) (swap func(), err error) {
+	state, err := rule.build(config, patterns)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		rule.mu.Lock()
+		defer rule.mu.Unlock()
+		rule.store(state, config, patterns)
+		log.Println("Rule hot reloaded")
+	}, nil
 }
 
-// Reload rule config
-func (rule *Rule) Reload(config configure.RuleConfig, patterns map[string]*configure.PatternConfig) {
-	log.Println("Rule hot reloaded")
-	rule.patterns = rule.patterns[:0]
-	rule.setUp(config, patterns)
+// setUp create the patterns of config and swap them in
+func (rule *Rule) setUp(config configure.RuleConfig, patterns map[string]*configure.PatternConfig) error {
+	state, err := rule.build(config, patterns)
+	if err != nil {
+		return err
+	}
+	rule.store(state, config, patterns)
+	return nil
 }
 
-func (rule *Rule) setUp(config configure.RuleConfig, patterns map[string]*configure.PatternConfig) {
-	rule.final = config.Final
-	pattern := NewDomainSuffixPattern("__internal__", "", nil)
-	rule.patterns = append(rule.patterns, pattern)
+// build the patterns of config, the internal pattern of direct domains is kept
+func (rule *Rule) build(config configure.RuleConfig, patterns map[string]*configure.PatternConfig) (*ruleState, error) {
+	state := &ruleState{final: config.Final}
+	if old, ok := rule.state.Load().(*ruleState); ok {
+		state.patterns = append(state.patterns, old.patterns[0])
+	} else {
+		state.patterns = append(state.patterns, NewDomainSuffixPattern("__internal__", "", nil))
+	}
 	for _, name := range config.Pattern {
 		if patternConfig, ok := patterns[name]; ok {
-			if pattern := CreatePattern(name, patternConfig); pattern != nil {
-				rule.patterns = append(rule.patterns, pattern)
+			pattern, err := CreatePattern(name, patternConfig)
+			if err != nil {
+				return nil, err
+			}
+			if pattern != nil {
+				state.patterns = append(state.patterns, pattern)
+			}
+		}
+	}
+	return state, nil
+}
+
+// store state of config, rule.mu is held by the caller except in NewRule
+func (rule *Rule) store(state *ruleState, config configure.RuleConfig, patterns map[string]*configure.PatternConfig) {
+	rule.state.Store(state)
+	rule.config = config
+	rule.patterns = patterns
+}
+
+// Serve fetch the rule-set subscriptions which are not cached or older than their interval every minute,
+// the patterns are swapped when one of them is changed. A failed fetch keeps the last good copy.
+func (rule *Rule) Serve(ctx context.Context) error {
+	ticker := time.NewTicker(60 * time.Second)
+	defer ticker.Stop()
+	for {
+		rule.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetClient set the http client which fetches the rule-set subscriptions
+func (rule *Rule) SetClient(client *http.Client) {
+	rule.mu.Lock()
+	rule.client = client
+	rule.mu.Unlock()
+}
+
+func (rule *Rule) refresh(ctx context.Context) {
+	rule.mu.Lock()
+	var subs []*subscription
+	for _, name := range rule.config.Pattern {
+		if patternConfig, ok := rule.patterns[name]; ok {
+			for _, url := range patternConfig.URL {
+				subs = append(subs, newSubscription(url, patternConfig))
 			}
 		}
 	}
+	client := rule.client
+	rule.mu.Unlock()
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	changed := false
+	for _, s := range subs {
+		if !s.due(time.Now()) {
+			continue
+		}
+		ok, err := s.fetch(ctx, client)
+		if err != nil {
+			log.Printf("[rule] fetch %s failed, the last good copy is kept: %v", s.url, err)
+			continue
+		}
+		changed = changed || ok
+	}
+	if !changed {
+		return
+	}
+
+	rule.mu.Lock()
+	defer rule.mu.Unlock()
+	if err := rule.setUp(rule.config, rule.patterns); err != nil {
+		log.Println("[rule] refresh rule-sets failed:", err)
+		return
+	}
+	log.Println("[rule] rule-sets refreshed")
 }
 
-func NewRule(config configure.RuleConfig, patterns map[string]*configure.PatternConfig) *Rule {
+// NewRule create the rule of config, the values of patterns are loaded from their rule-set files and cached subscriptions
+func NewRule(config configure.RuleConfig, patterns map[string]*configure.PatternConfig) (*Rule, error) {
 	rule := new(Rule)
-	rule.setUp(config, patterns)
-	return rule
+	if err := rule.setUp(config, patterns); err != nil {
+		return nil, err
+	}
+	return rule, nil
 }
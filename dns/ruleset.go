@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/FlowerWrong/tun2socks/configure"
+	"github.com/FlowerWrong/tun2socks/util"
+)
+
+// rule types of surge and clash rule-sets, DOMAIN is read as DOMAIN-SUFFIX like the importers do, its subdomains match too
+var ruleSetSchemes = map[string]string{
+	"DOMAIN":         schemeDomainSuffix,
+	"DOMAIN-SUFFIX":  schemeDomainSuffix,
+	"DOMAIN-KEYWORD": schemeDomainKeyword,
+	"IP-CIDR":        schemeIPCIDR,
+	"GEOIP":          schemeIPCountry,
+}
+
+// RuleSetError is a syntax error of a line of a rule-set
+type RuleSetError struct {
+	File string // empty when the rule-set is not read from a file
+	Line int
+	Err  error
+}
+
+func (e *RuleSetError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+// ReadRuleSet read the values of scheme from a rule-set, # and // start a comment line.
+// A line is a value, eg: google.com, or a surge rule without policy, eg: DOMAIN-SUFFIX,google.com,
+// DOMAIN rules are DOMAIN-SUFFIX values, the rules of other schemes are skipped. The payload of a clash rule-provider is read too,
+// eg: - '+.google.com' or - DOMAIN-SUFFIX,google.com.
+func ReadRuleSet(r io.Reader, scheme string) ([]string, error) {
+	var vals []string
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == "payload:" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		// clash payload item
+		if strings.HasPrefix(line, "- ") {
+			line = strings.Trim(strings.TrimSpace(line[2:]), `'"`)
+		}
+
+		val := line
+		if fields := strings.Split(line, ","); len(fields) > 1 {
+			if ruleSetSchemes[strings.ToUpper(fields[0])] != scheme {
+				continue
+			}
+			val = strings.TrimSpace(fields[1])
+		}
+		val, err := ruleSetValue(val, scheme)
+		if err != nil {
+			return nil, &RuleSetError{Line: n, Err: err}
+		}
+		if val != "" {
+			vals = append(vals, val)
+		}
+	}
+	return vals, scanner.Err()
+}
+
+// ruleSetValue check a value of scheme, empty means skipped
+func ruleSetValue(val, scheme string) (string, error) {
+	if val == "" || strings.ContainsAny(val, " \t") {
+		return "", fmt.Errorf("invalid %s value %q", scheme, val)
+	}
+	switch scheme {
+	case schemeDomainSuffix:
+		// clash domain rule-set, +.google.com and .google.com are google.com and its subdomains
+		return strings.TrimPrefix(strings.TrimPrefix(val, "+"), "."), nil
+	case schemeIPCIDR:
+		route, err := util.ParseRoute(val)
+		if err != nil {
+			return "", err
+		}
+		if route.IP.To4() == nil {
+			// ipv6 is not supported by IPCIDRPattern
+			return "", nil
+		}
+		return route.String(), nil
+	case schemeIPCountry:
+		if len(val) != 2 {
+			return "", fmt.Errorf("invalid country %q", val)
+		}
+		return strings.ToUpper(val), nil
+	}
+	return val, nil
+}
+
+// readPatternFiles read the rule-set files and the cached subscriptions of a pattern, errors are file:line: message
+func readPatternFiles(config *configure.PatternConfig) ([]string, error) {
+	var files []string
+	for _, name := range config.File {
+		files = append(files, config.Path(name))
+	}
+	for _, url := range config.URL {
+		cache := config.CacheFile(url)
+		if _, err := os.Stat(cache); err != nil {
+			log.Printf("[rule] %s is not cached yet", url)
+			continue
+		}
+		files = append(files, cache)
+	}
+
+	var vals []string
+	for _, filename := range files {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		list, err := ReadRuleSet(f, config.Scheme)
+		f.Close()
+		if e, ok := err.(*RuleSetError); ok {
+			e.File = filename
+			return nil, e
+		}
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, list...)
+	}
+	return vals, nil
+}
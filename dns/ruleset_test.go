@@ -0,0 +1,115 @@
+package dns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/FlowerWrong/tun2socks/configure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadRuleSet(t *testing.T) {
+	surge := `# surge rule-set
+DOMAIN-SUFFIX,google.com
+DOMAIN-KEYWORD,youtube
+DOMAIN,www.example.com
+IP-CIDR,91.108.4.0/22,no-resolve
+IP-CIDR6,2001:b28:f23d::/48
+twitter.com
+`
+	vals, err := ReadRuleSet(strings.NewReader(surge), schemeDomainSuffix)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"google.com", "www.example.com", "twitter.com"}, vals)
+
+	vals, err = ReadRuleSet(strings.NewReader(surge), schemeDomainKeyword)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"youtube", "twitter.com"}, vals)
+
+	clash := `payload:
+  - '+.google.com'
+  - ".youtube.com"
+  - DOMAIN-SUFFIX,twitter.com
+  - DOMAIN,www.example.com
+`
+	vals, err = ReadRuleSet(strings.NewReader(clash), schemeDomainSuffix)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"google.com", "youtube.com", "twitter.com", "www.example.com"}, vals)
+
+	vals, err = ReadRuleSet(strings.NewReader("91.108.4.0/22\n8.8.8.8\n2001:b28:f23d::/48\n"), schemeIPCIDR)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"91.108.4.0/22", "8.8.8.8/32"}, vals)
+
+	_, err = ReadRuleSet(strings.NewReader("91.108.4.0/22\n\n91.108.4.0/33\n"), schemeIPCIDR)
+	if assert.IsType(t, &RuleSetError{}, err) {
+		assert.Equal(t, 3, err.(*RuleSetError).Line)
+	}
+
+	_, err = ReadRuleSet(strings.NewReader("GEOIP,China\n"), schemeIPCountry)
+	assert.EqualError(t, err, `line 1: invalid country "China"`)
+}
+
+func TestCreatePatternFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ruleset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "proxy.list")
+	if err := ioutil.WriteFile(filename, []byte("DOMAIN-SUFFIX,google.com\nDOMAIN-SUFFIX,\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &configure.PatternConfig{Proxy: "A", Scheme: schemeDomainSuffix, V: []string{"twitter.com"}, File: []string{filename}}
+	_, err = CreatePattern("proxy", config)
+	assert.EqualError(t, err, `pattern "proxy": `+filename+`:2: invalid DOMAIN-SUFFIX value ""`)
+
+	if err := ioutil.WriteFile(filename, []byte("DOMAIN-SUFFIX,google.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pattern, err := CreatePattern("proxy", config)
+	assert.Nil(t, err)
+	checkCases(t, "A", pattern, map[interface{}]bool{
+		"www.google.com": true,
+		"twitter.com":    true,
+		"example.com":    false,
+	})
+	assert.Equal(t, []string{"twitter.com"}, config.V)
+}
+
+func TestRulePrepare(t *testing.T) {
+	patterns := map[string]*configure.PatternConfig{
+		"proxy": {Proxy: "A", Scheme: schemeDomainSuffix, V: []string{"google.com"}},
+		"file":  {Proxy: "B", Scheme: schemeDomainSuffix, File: []string{"/nonexistent/proxy.list"}},
+	}
+	rule, err := NewRule(configure.RuleConfig{Pattern: []string{"proxy"}, Final: "direct"}, patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule.DirectDomain("lan.example.com")
+
+	// an invalid rule-set file keeps the running rule
+	_, err = rule.Prepare(configure.RuleConfig{Pattern: []string{"file"}, Final: "B"}, patterns)
+	assert.NotNil(t, err)
+	_, proxy := rule.Proxy("www.google.com")
+	assert.Equal(t, "A", proxy)
+
+	// nothing is swapped until swap is called
+	patterns["twitter"] = &configure.PatternConfig{Proxy: "C", Scheme: schemeDomainSuffix, V: []string{"twitter.com"}}
+	swap, err := rule.Prepare(configure.RuleConfig{Pattern: []string{"twitter"}, Final: "C"}, patterns)
+	assert.Nil(t, err)
+	_, proxy = rule.Proxy("twitter.com")
+	assert.Equal(t, "direct", proxy)
+	swap()
+	_, proxy = rule.Proxy("twitter.com")
+	assert.Equal(t, "C", proxy)
+	_, proxy = rule.Proxy("www.google.com")
+	assert.Equal(t, "C", proxy)
+
+	// the direct domains are kept
+	ok, proxy := rule.Proxy("lan.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "", proxy)
+}
@@ -0,0 +1,110 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/FlowerWrong/tun2socks/configure"
+	"github.com/FlowerWrong/tun2socks/util"
+)
+
+// maxRuleSetSize limit the body of a rule-set subscription
+const maxRuleSetSize = 32 << 20
+
+// subscription is a remote rule-set of a pattern. The last good copy is cached in a file, so it is loaded
+// without network on start, the mtime of the cache is the last time it was fetched.
+type subscription struct {
+	url      string
+	cache    string
+	scheme   string
+	interval time.Duration
+}
+
+// cacheMeta is the validators of the cached copy, kept next to the cache
+type cacheMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last-modified,omitempty"`
+}
+
+func newSubscription(url string, config *configure.PatternConfig) *subscription {
+	return &subscription{
+		url:      url,
+		cache:    config.CacheFile(url),
+		scheme:   config.Scheme,
+		interval: time.Duration(config.Interval) * time.Second,
+	}
+}
+
+// due report whether the subscription is not cached or the cache is older than interval
+func (s *subscription) due(now time.Time) bool {
+	info, err := os.Stat(s.cache)
+	if err != nil {
+		return true
+	}
+	return s.interval > 0 && now.Sub(info.ModTime()) >= s.interval
+}
+
+// fetch the rule-set with the validators of the cache, changed is false if it is not modified.
+// The cache is only replaced by a rule-set without syntax errors.
+func (s *subscription) fetch(ctx context.Context, client *http.Client) (changed bool, err error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	var meta cacheMeta
+	if _, err := os.Stat(s.cache); err == nil {
+		if data, err := ioutil.ReadFile(s.cache + ".meta"); err == nil {
+			json.Unmarshal(data, &meta)
+		}
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		now := time.Now()
+		return false, os.Chtimes(s.cache, now, now)
+	case http.StatusOK:
+	default:
+		return false, fmt.Errorf("%s: %s", s.url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRuleSetSize+1))
+	if err != nil {
+		return false, err
+	}
+	if len(body) > maxRuleSetSize {
+		return false, fmt.Errorf("%s: larger than %d bytes", s.url, maxRuleSetSize)
+	}
+	if _, err := ReadRuleSet(bytes.NewReader(body), s.scheme); err != nil {
+		return false, fmt.Errorf("%s: %v", s.url, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.cache), 0755); err != nil {
+		return false, err
+	}
+	if err := util.WriteFileAtomic(s.cache, body, 0644); err != nil {
+		return false, err
+	}
+	meta = cacheMeta{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	data, _ := json.Marshal(meta)
+	return true, util.WriteFileAtomic(s.cache+".meta", data, 0644)
+}
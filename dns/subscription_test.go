@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/FlowerWrong/tun2socks/configure"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleRefresh(t *testing.T) {
+	body := atomic.Value{}
+	body.Store("DOMAIN-SUFFIX,google.com\n")
+	var fetches, notModified int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		etag := fmt.Sprintf("%q", fmt.Sprint(len(body.Load().(string))))
+		if r.Header.Get("If-None-Match") == etag {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, body.Load().(string))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "subscription")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "config.yaml")
+	config := fmt.Sprintf(`
+proxy:
+  A:
+    url: socks5://127.0.0.1:1080
+pattern:
+  proxy-domain:
+    proxy: A
+    scheme: DOMAIN-SUFFIX
+    url: [%s/proxy.list]
+    interval: 3600
+rule:
+  pattern: [proxy-domain]
+`, server.URL)
+	if err := ioutil.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := new(configure.AppConfig)
+	if err := cfg.Parse(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	// not cached yet
+	rule, err := NewRule(cfg.Rule, cfg.Pattern)
+	assert.Nil(t, err)
+	matched, _ := rule.Proxy("www.google.com")
+	assert.False(t, matched)
+
+	rule.refresh(context.Background())
+	matched, proxy := rule.Proxy("www.google.com")
+	assert.True(t, matched)
+	assert.Equal(t, "A", proxy)
+	cache := cfg.Pattern["proxy-domain"].CacheFile(server.URL + "/proxy.list")
+	assert.FileExists(t, cache)
+
+	// fresh cache is not fetched again
+	rule.refresh(context.Background())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// expired cache is validated by etag
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(cache, old, old)
+	rule.refresh(context.Background())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&notModified))
+
+	// an invalid rule-set keeps the last good copy
+	body.Store("DOMAIN-SUFFIX,twitter.com\nDOMAIN-SUFFIX,\n")
+	os.Chtimes(cache, old, old)
+	rule.refresh(context.Background())
+	matched, _ = rule.Proxy("www.google.com")
+	assert.True(t, matched)
+	matched, _ = rule.Proxy("twitter.com")
+	assert.False(t, matched)
+
+	body.Store("DOMAIN-SUFFIX,twitter.com\n")
+	rule.refresh(context.Background())
+	matched, _ = rule.Proxy("twitter.com")
+	assert.True(t, matched)
+	matched, _ = rule.Proxy("www.google.com")
+	assert.False(t, matched)
+
+	// the cache is loaded offline on start
+	server.Close()
+	rule, err = NewRule(cfg.Rule, cfg.Pattern)
+	assert.Nil(t, err)
+	matched, _ = rule.Proxy("twitter.com")
+	assert.True(t, matched)
+}
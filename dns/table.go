@@ -2,6 +2,7 @@
 package dns
 
 import (
+	"context"
 	"log"
 	"net"
 	"sync"
@@ -130,6 +131,30 @@ func (c *DNSTable) Set(domain string, proxy string) *DomainRecord {
 	return record
 }
 
+// TableStats is the size of the dns table
+type TableStats struct {
+	Records         int // hijacked domains
+	NonProxyDomains int
+	PoolUsed        int
+	PoolCapacity    int
+}
+
+// Stats of the records, non proxy domains and ip pool
+func (c *DNSTable) Stats() TableStats {
+	c.recordsLock.Lock()
+	stats := TableStats{
+		Records:      len(c.records),
+		PoolUsed:     c.ipPool.Used(),
+		PoolCapacity: c.ipPool.Capacity(),
+	}
+	c.recordsLock.Unlock()
+
+	c.npdLock.Lock()
+	stats.NonProxyDomains = len(c.nonProxyDomains)
+	c.npdLock.Unlock()
+	return stats
+}
+
 func (c *DNSTable) IsNonProxyDomain(domain string) bool {
 	c.npdLock.Lock()
 	defer c.npdLock.Unlock()
@@ -178,13 +203,19 @@ func (c *DNSTable) clearExpiredDomain(now time.Time) {
 	}
 }
 
-func (c *DNSTable) Serve() error {
-	tick := time.Tick(60 * time.Second)
-	for now := range tick {
-		c.clearExpiredDomain(now)
-		c.clearExpiredNonProxyDomain(now)
+// Serve clear expired records every minute until ctx is done
+func (c *DNSTable) Serve(ctx context.Context) error {
+	ticker := time.NewTicker(60 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			c.clearExpiredDomain(now)
+			c.clearExpiredNonProxyDomain(now)
+		}
 	}
-	return nil
 }
 
 func (c *DNSTable) Reload(ip net.IP, subnet *net.IPNet) {
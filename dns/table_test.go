@@ -0,0 +1,20 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDNSTable_Stats(t *testing.T) {
+	table := NewDnsTable(ip, subnet)
+	table.Set("example.com", "A")
+	table.Set("example.org", "A")
+	table.SetNonProxyDomain("baidu.com", 600)
+
+	stats := table.Stats()
+	assert.Equal(t, 2, stats.Records)
+	assert.Equal(t, 1, stats.NonProxyDomains)
+	assert.Equal(t, 3, stats.PoolUsed)
+	assert.Equal(t, dnsIPPool.Capacity(), stats.PoolCapacity)
+}
@@ -1,19 +1,21 @@
 package tun2socks
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"runtime"
+	"sync"
 
 	"github.com/FlowerWrong/netstack/tcpip"
 	"github.com/FlowerWrong/netstack/tcpip/stack"
 	"github.com/FlowerWrong/tun2socks/configure"
 	"github.com/FlowerWrong/tun2socks/dns"
 	"github.com/FlowerWrong/tun2socks/util"
-	"github.com/FlowerWrong/water"
+	"github.com/yl2chen/cidranger"
 )
 
 // App struct
@@ -23,128 +25,349 @@ type App struct {
 	Cfg                   *configure.AppConfig
 	Proxies               *configure.Proxies
 	S                     *stack.Stack
-	Ifce                  *water.Interface
+	Dev                   Device
 	HookPort              uint16
 	Version               float64
 	NetworkProtocolNumber tcpip.NetworkProtocolNumber
+	IgnoreRanger          cidranger.Ranger // destinations which should not be proxied
+	Routes                *RouteManager    // routes installed to the tun
+
+	tcpTunnels sync.Map // *TCPTunnel -> struct{}
+	udpTunnels sync.Map // id -> *UDPTunnel
+	udpNat     sync.Map // local port -> stack.TransportEndpointID
+
+	mu         sync.Mutex
+	active     bool               // start, Run or Exec is in progress, from the config to the shutdown
+	cancel     context.CancelFunc // cancel the running context, installed before the setup so Stop is never lost
+	fdMode     bool               // run on an opened tun fd, interface and routes are not managed
+	sysDNS     bool               // system dns is set to the fake dns, restored on shutdown
+	autoRoute  *util.AutoRoute    // installed auto-route, removed on shutdown
+	killSwitch *util.KillSwitch   // installed kill switch, removed on shutdown
+	splitRoute *util.SplitRoute   // installed uid and cgroup split route, removed on shutdown
+	resolvConf *util.ResolvConf   // replaced /etc/resolv.conf, restored on shutdown
+	resolvectl string             // tun link whose dns is set by resolvectl, reverted on shutdown
+	report     *ShutdownReport    // report of the last shutdown
 }
 
-// Stop ...
+var errAlreadyRunning = errors.New("tun2socks is already running")
+
+// Stop the running tun2socks, StartTun2socks will return after the shutdown finished, see ShutdownReport.
 func (app *App) Stop() {
-	if UseTCPNetstack {
-		close(QuitTCPNetstack)
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if app.cancel != nil {
+		app.cancel()
+		app.cancel = nil
+	}
+}
+
+// StartTun2socks create a tun interface from configFile and run tun2socks on it until ctx is done or Stop is called.
+// If general.tun-fd is set, that fd is used instead of creating a tun interface.
+// It can be called again after it returns.
+func (app *App) StartTun2socks(ctx context.Context, configFile string) error {
+	return app.start(ctx, configFile, -1)
+}
+
+// StartTun2socksWithFd run tun2socks on an already opened tun fd until ctx is done or Stop is called,
+// eg: from android VpnService. The interface and routes are not set up, the owner of the fd should do it.
+func (app *App) StartTun2socksWithFd(ctx context.Context, configFile string, tunFd int) error {
+	if tunFd < 0 {
+		return &TunError{Op: "open fd", Err: fmt.Errorf("invalid fd %d", tunFd)}
 	}
-	if UseUDPNetstack {
-		close(QuitUDPNetstack)
+	return app.start(ctx, configFile, tunFd)
+}
+
+func (app *App) start(ctx context.Context, configFile string, tunFd int) error {
+	ctx, err := app.begin(ctx)
+	if err != nil {
+		return err
 	}
-	if UseDNS {
-		close(QuitDNS)
+	defer app.end()
+
+	if err := app.Config(configFile); err != nil {
+		return err
+	}
+	// before the tun and routes are set up, so they are not left behind
+	if app.Cfg.General.DropPrivileges {
+		if err := util.CanDropPrivileges(); err != nil {
+			return &PrivilegeError{User: app.Cfg.General.User, Err: err}
+		}
+	}
+	if tunFd < 0 {
+		tunFd = app.Cfg.General.TunFd
+	}
+	app.fdMode = tunFd >= 0
+	recoverResolvConf()
+	if app.fdMode {
+		app.Dev = NewFdDevice(tunFd, fmt.Sprintf("fd%d", tunFd), app.Cfg.General.Mtu)
+		log.Println("[tun] use opened tun fd", tunFd)
+		if app.Cfg.Route.AutoRoute || app.Cfg.Route.KillSwitch || len(app.Cfg.Route.UID) > 0 || len(app.Cfg.Route.Cgroup) > 0 {
+			log.Println("[route] auto-route, kill-switch, uid and cgroup are ignored, routes of an opened tun fd are left to its owner")
+		}
+	} else {
+		if err := app.NewTun(); err != nil {
+			return err
+		}
+		// a partial failure is logged and retried by a reload, a tun without any route is useless
+		summary := summarizeRoutes(app.AddRoutes())
+		log.Printf("[route] routes added, %s", summary)
+		if summary.failed > 0 && summary.added == 0 {
+			app.shutdown(new(util.WaitGroupWrapper), func() {})
+			return &TunError{Op: "add routes", Err: fmt.Errorf("all %d routes failed, first %v", summary.failed, summary.err)}
+		}
+		if app.Cfg.Route.AutoRoute {
+			if err := app.SetupAutoRoute(); err != nil {
+				app.shutdown(new(util.WaitGroupWrapper), func() {})
+				return err
+			}
+			if len(app.Cfg.Route.UID) > 0 || len(app.Cfg.Route.Cgroup) > 0 {
+				log.Println("[route] uid and cgroup are ignored, auto-route captures all the users")
+			}
+		} else if err := app.SetupSplitRoute(); err != nil {
+			app.shutdown(new(util.WaitGroupWrapper), func() {})
+			return err
+		}
+		if app.Cfg.Route.KillSwitch {
+			if err := app.SetupKillSwitch(); err != nil {
+				app.shutdown(new(util.WaitGroupWrapper), func() {})
+				return err
+			}
+		}
+	}
+	if app.Cfg.General.DropPrivileges {
+		if err := app.DropPrivileges(); err != nil {
+			// the dns conn bound before dropping, only in fake mode
+			if app.FakeDNS != nil && app.FakeDNS.Server.PacketConn != nil {
+				app.FakeDNS.Server.PacketConn.Close()
+			}
+			app.shutdown(new(util.WaitGroupWrapper), func() {})
+			return err
+		}
 	}
-	if UsePprof {
-		close(QuitPprof)
+	app.SignalHandler(ctx)
+	return app.run(ctx, nil)
+}
+
+// Run tun2socks on app.Dev with app.Cfg until ctx is done or Stop is called.
+// Then new flows are refused, live tunnels have general.grace-period to finish,
+// system dns is restored, routes are removed and app.Dev is closed before it returns.
+// App must be configured by Config and have a Dev, eg: from NewTun or NewPipeDevice.
+func (app *App) Run(ctx context.Context) error {
+	ctx, err := app.begin(ctx)
+	if err != nil {
+		return err
 	}
+	defer app.end()
+	return app.run(ctx, nil)
 }
 
-// StartTun2socks ...
-func (app *App) StartTun2socks(configFile string) {
-	app.Config(configFile).NewTun().AddRoutes().SignalHandler()
-	app.NetworkProtocolNumber = NewNetstack(app)
+// run tun2socks until ctx is done, between begin and end. ready is closed when all the listeners are up,
+// it is left open if run fails or ctx is done during the setup.
+func (app *App) run(ctx context.Context, ready chan<- struct{}) error {
+	if app.IgnoreRanger == nil {
+		app.IgnoreRanger = NewIgnoreRanger()
+	}
 
+	// the netstack outlives ctx, draining tunnels still need it
+	stackCtx, stopStack := context.WithCancel(context.Background())
 	wgw := new(util.WaitGroupWrapper)
-	UseTCPNetstack = true
-	wgw.Wrap(func() {
-		app.NewTCPEndpointAndListenIt()
-	})
+	proto, err := NewNetstack(stackCtx, app)
+	if err != nil {
+		app.shutdown(wgw, stopStack)
+		return err
+	}
+	app.NetworkProtocolNumber = proto
+
+	// the first listener error stops all the others
+	var runErr error
+	var errOnce sync.Once
+	exit := func(err error) {
+		if err != nil {
+			errOnce.Do(func() {
+				runErr = err
+				app.Stop()
+			})
+		}
+	}
+
+	// the netstack endpoints are set up before serving, so their errors are returned before ready
+	tcpEp, tcpWq, err := app.listenTCP()
+	if err != nil {
+		app.shutdown(wgw, stopStack)
+		return err
+	}
 	if app.Cfg.UDP.Enabled {
-		UseUDPNetstack = true
+		udpEp, udpWq, err := app.listenUDP()
+		if err != nil {
+			tcpEp.Close()
+			app.shutdown(wgw, stopStack)
+			return err
+		}
 		wgw.Wrap(func() {
-			app.NewUDPEndpointAndListenIt()
+			exit(app.readUDP(ctx, udpEp, udpWq))
 		})
 	}
+	wgw.Wrap(func() {
+		exit(app.acceptTCP(ctx, tcpEp, tcpWq))
+	})
 	if app.Cfg.DNS.DNSMode == FakeMode {
-		go app.FakeDNS.DNSTablePtr.Serve()
+		go app.FakeDNS.DNSTablePtr.Serve(ctx)
+		go app.FakeDNS.RulePtr.Serve(ctx)
 
-		UseDNS = true
+		// wait the dns server to start or fail, so its shutdown can not miss it
+		started, served := make(chan struct{}), make(chan struct{})
+		app.FakeDNS.Server.NotifyStartedFunc = func() { close(started) }
 		wgw.Wrap(func() {
-			app.ServeDNS()
+			exit(app.ServeDNS())
+			close(served)
 		})
-		go app.StopDNS()
+		select {
+		case <-started:
+		case <-served:
+		}
 	}
 
 	if app.Cfg.Pprof.Enabled {
-		UsePprof = true
+		// created before serving, so a shutdown can not miss it
+		app.Pprof = &http.Server{Addr: fmt.Sprintf("%s:%d", app.Cfg.Pprof.ProfHost, app.Cfg.Pprof.ProfPort)}
 		wgw.Wrap(func() {
-			app.ServePprof()
+			exit(app.ServePprof())
 		})
-		go app.StopPprof()
 	}
 
-	log.Println(fmt.Sprintf("[app] run tun2socks(%.2f) success", app.Version))
-	wgw.WaitGroup.Wait()
+	if ctx.Err() == nil {
+		log.Println(fmt.Sprintf("[app] run tun2socks(%.2f) success", app.Version))
+		if ready != nil {
+			close(ready)
+		}
+	}
+	<-ctx.Done()
+	log.Println("[app] tun2socks stopping")
+	// tcp and udp listeners quit with ctx, dns and pprof servers need a shutdown
+	if app.Cfg.DNS.DNSMode == FakeMode {
+		app.StopDNS()
+	}
+	if app.Cfg.Pprof.Enabled {
+		app.StopPprof()
+	}
+	report := app.shutdown(wgw, stopStack)
+	log.Println("[app] tun2socks stopped,", report)
+	return runErr
+}
+
+func (app *App) running() bool {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.active
+}
+
+// begin mark app running and install the cancel func of its context, so a Stop during the setup cancels it too.
+// Only one start, Run or Exec can be in progress.
+func (app *App) begin(ctx context.Context) (context.Context, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if app.active {
+		return nil, errAlreadyRunning
+	}
+	app.active = true
+	ctx, app.cancel = context.WithCancel(ctx)
+	return ctx, nil
+}
+
+// end cancel the context of begin, app can be started again
+func (app *App) end() {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if app.cancel != nil {
+		app.cancel()
+		app.cancel = nil
+	}
+	app.active = false
 }
 
 // NewTun create a tun interface
-func (app *App) NewTun() *App {
-	NewTun(app)
-	return app
+func (app *App) NewTun() error {
+	return NewTun(app)
 }
 
-// AddRoutes add route table
-func (app *App) AddRoutes() *App {
-	name := app.Ifce.Name()
-	for _, val := range app.Cfg.Route.V {
-		_, subnet, _ := net.ParseCIDR(val)
-		if subnet != nil {
-			util.AddNetRoute(name, subnet)
-		} else {
-			util.AddHostRoute(name, val)
-		}
+// AddRoutes install [route] entries to the tun, routes dropped from the config since the last call are deleted.
+// All of them are deleted on shutdown.
+func (app *App) AddRoutes() []RouteResult {
+	app.mu.Lock()
+	if app.Routes == nil {
+		app.Routes = NewRouteManager()
 	}
-	return app
+	app.mu.Unlock()
+	return app.Routes.Sync(app.Dev.Name(), app.Cfg.Routes)
 }
 
 // Config parse config from file
-func (app *App) Config(configFile string) *App {
+func (app *App) Config(configFile string) error {
 	// parse config
-	app.Cfg = new(configure.AppConfig)
-	err := app.Cfg.Parse(configFile)
+	cfg := new(configure.AppConfig)
+	err := cfg.Parse(configFile)
 	if err != nil {
-		log.Fatal("Get default proxy failed", err)
+		return &ConfigError{File: configFile, Err: err}
 	}
 
-	if app.Cfg.DNS.DNSMode == FakeMode {
-		app.FakeDNS, err = dns.NewFakeDNSServer(app.Cfg)
-		if err != nil {
-			log.Fatal("New fake dns server failed", err)
-		}
+	proxies, err := configure.NewProxies(cfg.Proxy)
+	if err != nil {
+		return &ProxyError{Err: err}
 	}
 
-	app.Proxies, err = configure.NewProxies(app.Cfg.Proxy)
-	if err != nil {
-		log.Fatalln("New proxies failed", err)
+	var fakeDNS *dns.DNS
+	if cfg.DNS.DNSMode == FakeMode {
+		fakeDNS, err = dns.NewFakeDNSServer(cfg)
+		if err != nil {
+			return &ConfigError{File: configFile, Err: err}
+		}
 	}
 
-	return app
+	app.Cfg = cfg
+	app.Proxies = proxies
+	app.FakeDNS = fakeDNS
+	return nil
 }
 
-// ReloadConfig reload config file
-func (app *App) ReloadConfig() {
+// ReloadConfig reload config file, the running config is kept if the new one is invalid
+func (app *App) ReloadConfig() error {
 	// parse config
 	file := app.Cfg.File
-	app.Cfg = new(configure.AppConfig)
-	err := app.Cfg.Parse(file)
+	cfg := new(configure.AppConfig)
+	err := cfg.Parse(file)
 	if err != nil {
-		log.Fatal("Get default proxy failed", err)
+		return &ConfigError{File: file, Err: err}
 	}
-	if app.Cfg.DNS.DNSMode == FakeMode {
-		app.FakeDNS.RulePtr.Reload(app.Cfg.Rule, app.Cfg.Pattern)
-
+	// the rule-set files are read and the proxies are created before anything is swapped,
+	// so a failure of either one keeps both running
+	swapRule := func() {}
+	if cfg.DNS.DNSMode == FakeMode && app.FakeDNS != nil {
+		if swapRule, err = app.FakeDNS.RulePtr.Prepare(cfg.Rule, cfg.Pattern); err != nil {
+			return &ConfigError{File: file, Err: err}
+		}
+	}
+	swapProxies, err := app.Proxies.Prepare(cfg.Proxy)
+	if err != nil {
+		return &ProxyError{Err: err}
+	}
+	swapRule()
+	swapProxies()
+	app.Cfg = cfg
+	if app.Cfg.DNS.DNSMode == FakeMode && app.FakeDNS != nil {
 		var ip, subnet, _ = net.ParseCIDR(app.Cfg.General.Network)
 		app.FakeDNS.DNSTablePtr.Reload(ip, subnet)
 	}
-	app.Proxies.Reload(app.Cfg.Proxy)
-	log.Println("Routes hot reloaded")
-	app.AddRoutes()
+	if !app.fdMode {
+		log.Printf("[route] routes hot reloaded, %s", summarizeRoutes(app.AddRoutes()))
+		app.reloadAutoRoute()
+		app.reloadSplitRoute()
+		app.reloadKillSwitch()
+	}
+	if app.FakeDNS != nil {
+		// a split route enabled by the reload marks the upstream queries too, so they do not loop into the tun
+		app.FakeDNS.SetMark(app.socketMark())
+	}
+	return nil
 }
 
 // SetAndResetSystemDNSServers ...
@@ -200,29 +423,19 @@ function flushCache {
 }
 `
 	} else if runtime.GOOS == "linux" {
-		shell = `
-function updateDNS {
-  case "$1" in
-    g|google)
-      echo "nameserver 8.8.8.8" | sudo tee /etc/resolv.conf
-      ;;
-    a|ali)
-      echo "nameserver 223.5.5.5" | sudo tee /etc/resolv.conf
-      ;;
-    l|local)
-      echo "nameserver 127.0.0.1" | sudo tee /etc/resolv.conf
-      ;;
-    *)
-      echo "You have failed to specify what to do correctly."
-      exit 1
-      ;;
-  esac
-}
-
-function flushCache {
-	echo "You need to flush cache yourself!!!"
-}
-`
+		var err error
+		if setFlag {
+			err = app.setLinuxDNS()
+		} else {
+			err = app.restoreLinuxDNS()
+		}
+		if err != nil && setFlag {
+			log.Println("[dns] set system dns failed", err)
+			log.Println("NOTE: please setup your dns server to 127.0.0.1 by hand.")
+		} else if err != nil {
+			log.Println("[dns] restore system dns failed", err)
+		}
+		return
 	} else if runtime.GOOS == "windows" {
 		var name string
 		var err error
@@ -261,11 +474,6 @@ updateDNS l
 			shell += `
 updateDNS d
 flushCache
-`
-		} else if runtime.GOOS == "linux" {
-			shell += `
-updateDNS a
-flushCache
 `
 		}
 	}
@@ -282,7 +490,7 @@ func (app *App) ActiveInterfaceName() (string, error) {
 		log.Println(intf)
 	}
 	for _, intf := range interfaces {
-		if intf.Flags&(1<<0) != 0 && intf.Flags&(1<<2) == 0 && intf.Flags&(1<<3) == 0 && app.Ifce.Name() != intf.Name {
+		if intf.Flags&(1<<0) != 0 && intf.Flags&(1<<2) == 0 && intf.Flags&(1<<3) == 0 && app.Dev.Name() != intf.Name {
 			return intf.Name, nil
 		}
 	}
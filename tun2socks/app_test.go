@@ -0,0 +1,387 @@
+package tun2socks
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlowerWrong/tun2socks/util"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeDNS(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.Close()
+
+	ip := env.lookup("www.example.com")
+	_, subnet, _ := net.ParseCIDR(testNetwork)
+	assert.True(t, subnet.Contains(ip), "%s should be a fake ip", ip)
+
+	record := env.app.FakeDNS.DNSTablePtr.GetByIP(ip)
+	require.NotNil(t, record)
+	assert.Equal(t, "www.example.com", record.Hostname)
+	assert.Equal(t, "A", record.Proxy)
+	assert.Equal(t, ip.String(), env.lookup("www.example.com").String(), "fake ip should be stable")
+
+	// domain without proxy is resolved by upstream
+	assert.Equal(t, testUpstreamIP.String(), env.lookup("direct.test").String())
+}
+
+func TestTCPTunnel(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.Close()
+
+	ip := env.lookup("echo.example.com")
+	const srcPort, dstPort = 40000, 80
+
+	env.writeTCP(ip, &layers.TCP{SrcPort: srcPort, DstPort: dstPort, Seq: 1000, SYN: true, Window: 65535}, nil)
+	synAck := env.expectTCP(ip, dstPort, srcPort, func(tcp *layers.TCP) bool {
+		return tcp.SYN && tcp.ACK
+	})
+	require.EqualValues(t, 1001, synAck.Ack)
+
+	seq, ack := uint32(1001), synAck.Seq+1
+	env.writeTCP(ip, &layers.TCP{SrcPort: srcPort, DstPort: dstPort, Seq: seq, Ack: ack, ACK: true, Window: 65535}, nil)
+
+	payload := []byte("hello tun2socks")
+	env.writeTCP(ip, &layers.TCP{SrcPort: srcPort, DstPort: dstPort, Seq: seq, Ack: ack, ACK: true, PSH: true, Window: 65535}, payload)
+
+	var echo []byte
+	for len(echo) < len(payload) {
+		segment := env.expectTCP(ip, dstPort, srcPort, func(tcp *layers.TCP) bool {
+			return len(tcp.Payload) > 0
+		})
+		echo = append(echo, segment.Payload...)
+	}
+	assert.Equal(t, payload, echo)
+	assert.Contains(t, env.socks.Targets(), "echo.example.com:80")
+}
+
+func TestState(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.Close()
+
+	ip := env.lookup("state.example.com")
+	const srcPort, dstPort = 40002, 80
+
+	env.writeTCP(ip, &layers.TCP{SrcPort: srcPort, DstPort: dstPort, Seq: 1000, SYN: true, Window: 65535}, nil)
+	synAck := env.expectTCP(ip, dstPort, srcPort, func(tcp *layers.TCP) bool {
+		return tcp.SYN && tcp.ACK
+	})
+	seq, ack := uint32(1001), synAck.Seq+1
+	env.writeTCP(ip, &layers.TCP{SrcPort: srcPort, DstPort: dstPort, Seq: seq, Ack: ack, ACK: true, Window: 65535}, nil)
+	payload := []byte("hello state")
+	env.writeTCP(ip, &layers.TCP{SrcPort: srcPort, DstPort: dstPort, Seq: seq, Ack: ack, ACK: true, PSH: true, Window: 65535}, payload)
+	var echo []byte
+	for len(echo) < len(payload) {
+		segment := env.expectTCP(ip, dstPort, srcPort, func(tcp *layers.TCP) bool {
+			return len(tcp.Payload) > 0
+		})
+		echo = append(echo, segment.Payload...)
+	}
+
+	state := env.app.State()
+	require.Len(t, state.TCP, 1)
+	tunnel := state.TCP[0]
+	assert.Equal(t, fmt.Sprintf("%v:%d", ip, dstPort), tunnel.Dst)
+	assert.Equal(t, "state.example.com", tunnel.Hostname)
+	assert.Equal(t, "A", tunnel.Proxy)
+	assert.EqualValues(t, len(payload), tunnel.Sent)
+	assert.EqualValues(t, len(payload), tunnel.Received)
+	require.NotNil(t, state.DNS)
+	assert.True(t, state.DNS.Records >= 1)
+	require.Len(t, state.Proxies, 1)
+	assert.True(t, state.Proxies[0].Default)
+
+	var b strings.Builder
+	_, err := state.WriteTo(&b)
+	require.Nil(t, err)
+	assert.Contains(t, b.String(), "tcp tunnels: 1")
+	assert.Contains(t, b.String(), "(state.example.com)")
+}
+
+func TestUDPTunnel(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.Close()
+
+	ip := env.lookup("udp.example.com")
+	const srcPort, dstPort = 50000, 9000
+
+	payload := []byte("hello udp")
+	env.writeUDP(ip, &layers.UDP{SrcPort: srcPort, DstPort: dstPort}, payload)
+
+	udp := env.expectUDP(ip, dstPort, srcPort)
+	assert.Equal(t, payload, udp.Payload)
+	assert.Contains(t, env.socks.Targets(), "udp.example.com:9000")
+}
+
+func TestIndependentApps(t *testing.T) {
+	env1 := newTestEnv(t)
+	defer env1.Close()
+	env2 := newTestEnv(t)
+	defer env2.Close()
+
+	// the same local port on both apps must not share nat state
+	const srcPort, dstPort = 50000, 9000
+	ip1 := env1.lookup("one.example.com")
+	ip2 := env2.lookup("two.example.com")
+	env1.writeUDP(ip1, &layers.UDP{SrcPort: srcPort, DstPort: dstPort}, []byte("one"))
+	env2.writeUDP(ip2, &layers.UDP{SrcPort: srcPort, DstPort: dstPort}, []byte("two"))
+
+	assert.Equal(t, []byte("one"), env1.expectUDP(ip1, dstPort, srcPort).Payload)
+	assert.Equal(t, []byte("two"), env2.expectUDP(ip2, dstPort, srcPort).Payload)
+	assert.Equal(t, []string{"one.example.com:9000"}, env1.socks.Targets())
+	assert.Equal(t, []string{"two.example.com:9000"}, env2.socks.Targets())
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.Close()
+
+	ip := env.lookup("drain.example.com")
+	const srcPort, dstPort = 40001, 80
+
+	env.writeTCP(ip, &layers.TCP{SrcPort: srcPort, DstPort: dstPort, Seq: 1000, SYN: true, Window: 65535}, nil)
+	synAck := env.expectTCP(ip, dstPort, srcPort, func(tcp *layers.TCP) bool {
+		return tcp.SYN && tcp.ACK
+	})
+	env.writeTCP(ip, &layers.TCP{SrcPort: srcPort, DstPort: dstPort, Seq: 1001, Ack: synAck.Seq + 1, ACK: true, Window: 65535}, nil)
+	deadline := time.Now().Add(testTimeout)
+	for syncMapLen(&env.app.tcpTunnels) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, 1, syncMapLen(&env.app.tcpTunnels))
+
+	// the idle tunnel is closed when the grace period expired
+	env.stop()
+	report := env.app.ShutdownReport()
+	require.NotNil(t, report)
+	assert.Equal(t, 1, report.ClosedTCP)
+	assert.Equal(t, 0, report.DrainedTCP)
+	assert.False(t, report.DNSRestored)
+	assert.Empty(t, report.RoutesRemoved)
+	assert.True(t, report.TunClosed)
+	assert.True(t, report.Duration >= time.Second, "grace period should be waited, took %v", report.Duration)
+	assert.Equal(t, 0, syncMapLen(&env.app.tcpTunnels))
+}
+
+func TestRunAgain(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.Close()
+	env.lookup("first.example.com")
+	env.stop()
+
+	// the listeners of a stopped run are closed
+	listenerClosed := func() {
+		pc, err := net.ListenPacket("udp", env.dnsAddr)
+		if assert.NoError(t, err, "dns listener survived") {
+			pc.Close()
+		}
+	}
+	listenerClosed()
+	goroutines := settledGoroutines()
+
+	env.start()
+	ip := env.lookup("again.example.com")
+	const srcPort, dstPort = 50001, 9000
+	env.writeUDP(ip, &layers.UDP{SrcPort: srcPort, DstPort: dstPort}, []byte("again"))
+	assert.Equal(t, []byte("again"), env.expectUDP(ip, dstPort, srcPort).Payload)
+	env.stop()
+
+	listenerClosed()
+	deadline := time.Now().Add(testTimeout)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, runtime.NumGoroutine() <= goroutines, "%d goroutines after the second run, %d after the first one", runtime.NumGoroutine(), goroutines)
+}
+
+// settledGoroutines wait the number of goroutines to stop changing and return it
+func settledGoroutines() int {
+	n := runtime.NumGoroutine()
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		m := runtime.NumGoroutine()
+		if m == n {
+			break
+		}
+		n = m
+	}
+	return n
+}
+
+func TestStopDuringSetup(t *testing.T) {
+	app := new(App)
+	ctx, err := app.begin(context.Background())
+	require.NoError(t, err)
+	assert.True(t, app.running())
+
+	// a second start is rejected while the first one is still in its setup
+	_, err = app.begin(context.Background())
+	assert.Equal(t, errAlreadyRunning, err)
+	assert.Equal(t, errAlreadyRunning, app.Run(context.Background()))
+
+	// Stop before run is not lost
+	app.Stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("Stop during the setup did not cancel the context")
+	}
+
+	app.end()
+	assert.False(t, app.running())
+	ctx, err = app.begin(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, ctx.Err())
+	app.end()
+}
+
+func TestRunNotReady(t *testing.T) {
+	// the dns port is taken, so run fails during the setup
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	dnsPort := pc.LocalAddr().(*net.UDPAddr).Port
+
+	dir, err := ioutil.TempDir("", "tun2socks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.ini")
+	content := fmt.Sprintf(testConfig, testNetwork, dnsPort, "127.0.0.1:53", "127.0.0.1:1080")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(content), 0644))
+
+	app := new(App)
+	require.NoError(t, app.Config(configFile))
+	app.Dev, _ = NewPipeDevice("pipe0", app.Cfg.General.Mtu)
+	ctx, err := app.begin(context.Background())
+	require.NoError(t, err)
+	defer app.end()
+
+	ready := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- app.run(ctx, ready)
+	}()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(testTimeout):
+		t.Fatal("run did not fail")
+	}
+	select {
+	case <-ready:
+		t.Error("a failed run must not be ready")
+	default:
+	}
+}
+
+func TestReloadInvalidConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tun2socks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(`
+proxy:
+  A:
+    url: socks5://127.0.0.1:1080
+`), 0644))
+
+	app := new(App)
+	require.NoError(t, app.Config(configFile))
+	cfg, proxies := app.Cfg, app.Proxies
+	require.Equal(t, "A", proxies.Default)
+
+	for _, content := range []string{
+		// not yaml
+		"proxy: [",
+		// a valid file with an invalid value, its proxy would be the new default
+		`
+proxy:
+  B:
+    url: socks5://127.0.0.1:1081
+pattern:
+  p:
+    proxy: B
+    scheme: IP
+    v: [1.2.3.4]
+rule:
+  pattern: [p]
+`,
+	} {
+		require.NoError(t, ioutil.WriteFile(configFile, []byte(content), 0644))
+		err := app.ReloadConfig()
+		configErr, ok := err.(*ConfigError)
+		if assert.True(t, ok, "%T %v", err, err) {
+			assert.Equal(t, configFile, configErr.File)
+		}
+		assert.True(t, cfg == app.Cfg, "the running config is replaced")
+		assert.True(t, proxies == app.Proxies)
+		assert.Equal(t, "A", app.Proxies.Default)
+		assert.Equal(t, "socks5://127.0.0.1:1080", app.Cfg.Proxy["A"].URL)
+		assert.Nil(t, app.Cfg.Proxy["B"])
+	}
+}
+
+func TestReloadSplitRoute(t *testing.T) {
+	var installed, removed []string
+	defer func(install, uninstall func(*util.SplitRoute) error) {
+		installSplitRoute, uninstallSplitRoute = install, uninstall
+	}(installSplitRoute, uninstallSplitRoute)
+	installSplitRoute = func(sr *util.SplitRoute) error {
+		installed = append(installed, fmt.Sprintf("table %d priority %d", sr.Table, sr.Priority))
+		return nil
+	}
+	uninstallSplitRoute = func(sr *util.SplitRoute) error {
+		removed = append(removed, fmt.Sprintf("table %d priority %d", sr.Table, sr.Priority))
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", "tun2socks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	config := func(route string) {
+		require.NoError(t, ioutil.WriteFile(configFile, []byte(`
+proxy:
+  A:
+    url: socks5://127.0.0.1:1080
+`+route), 0644))
+	}
+
+	config("")
+	app := new(App)
+	require.NoError(t, app.Config(configFile))
+	app.Dev, _ = NewPipeDevice("tun0", 1500)
+	app.Routes, _ = newFakeRouteManager()
+	assert.Equal(t, 0, app.FakeDNS.Mark())
+
+	// uid is enabled by the reload, the upstream dns is marked from then on
+	config("route:\n  uid: [\"1000\"]\n")
+	require.NoError(t, app.ReloadConfig())
+	assert.Equal(t, []string{"table 2022 priority 9000"}, installed)
+	assert.Empty(t, removed)
+	assert.Equal(t, 2022, app.FakeDNS.Mark())
+
+	// the previous rules are removed at their own priority and table
+	config("route:\n  uid: [\"1000\"]\n  rule-priority: 9100\n  table: 2030\n")
+	require.NoError(t, app.ReloadConfig())
+	assert.Equal(t, []string{"table 2022 priority 9000", "table 2030 priority 9100"}, installed)
+	assert.Equal(t, []string{"table 2022 priority 9000"}, removed)
+
+	config("")
+	require.NoError(t, app.ReloadConfig())
+	assert.Equal(t, []string{"table 2022 priority 9000", "table 2030 priority 9100"}, removed)
+	assert.Nil(t, app.splitRoute)
+	assert.Equal(t, 0, app.FakeDNS.Mark())
+}
@@ -0,0 +1,105 @@
+package tun2socks
+
+import (
+	"log"
+	"net"
+	"net/url"
+
+	"github.com/FlowerWrong/tun2socks/util"
+)
+
+// SetupAutoRoute capture the default route into the tun by policy routing, see util.AutoRoute.
+// The proxy servers are excluded, so are the sockets marked with route.fwmark.
+func (app *App) SetupAutoRoute() error {
+	ar := &util.AutoRoute{
+		Tun:      app.Dev.Name(),
+		Table:    app.Cfg.Route.Table,
+		Mark:     app.Cfg.Route.FWMark,
+		Priority: app.Cfg.Route.RulePriority,
+		Exclude:  app.proxyNets(),
+	}
+	if err := ar.Install(); err != nil {
+		return &TunError{Op: "auto route", Err: err}
+	}
+	log.Printf("[route] auto route to %s by table %d, fwmark %d and %d proxy servers are excluded", ar.Tun, ar.Table, ar.Mark, len(ar.Exclude))
+
+	app.mu.Lock()
+	app.autoRoute = ar
+	app.mu.Unlock()
+	return nil
+}
+
+// reloadAutoRoute exclude the proxy servers of the reloaded config, the table and fwmark are kept
+func (app *App) reloadAutoRoute() {
+	app.mu.Lock()
+	ar := app.autoRoute
+	app.mu.Unlock()
+	if ar == nil {
+		return
+	}
+	ar.Exclude = app.proxyNets()
+	if err := ar.Install(); err != nil {
+		log.Println("[route] reload auto route failed", err)
+	}
+}
+
+// removeAutoRoute delete the rules and the default route of auto-route
+func (app *App) removeAutoRoute() (bool, error) {
+	app.mu.Lock()
+	ar := app.autoRoute
+	app.autoRoute = nil
+	app.mu.Unlock()
+	if ar == nil {
+		return false, nil
+	}
+	if err := ar.Remove(); err != nil {
+		log.Println("[route] remove auto route failed", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// socketMark of the sockets opened by tun2socks, 0 if none of auto-route, split route and the kill switch is running
+func (app *App) socketMark() int {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if app.autoRoute != nil {
+		return app.autoRoute.Mark
+	}
+	if app.splitRoute != nil {
+		return app.splitRoute.FWMark
+	}
+	if app.killSwitch != nil {
+		return app.killSwitch.Mark
+	}
+	return 0
+}
+
+// proxyNets resolve the proxy servers in [proxy] to /32 or /128 subnets
+func (app *App) proxyNets() []*net.IPNet {
+	var nets []*net.IPNet
+	seen := make(map[string]bool)
+	for name, proxy := range app.Cfg.Proxy {
+		u, err := url.Parse(proxy.URL)
+		if err != nil {
+			continue
+		}
+		host := u.Hostname()
+		ips := []net.IP{net.ParseIP(host)}
+		if ips[0] == nil {
+			if ips, err = net.LookupIP(host); err != nil {
+				log.Printf("[route] resolve proxy %q server %s failed: %v", name, host, err)
+				continue
+			}
+		}
+		for _, ip := range ips {
+			ipNet, err := util.ParseRoute(ip.String())
+			if err != nil || seen[ipNet.String()] {
+				continue
+			}
+			seen[ipNet.String()] = true
+			nets = append(nets, ipNet)
+		}
+	}
+	return nets
+}
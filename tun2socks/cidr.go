@@ -6,12 +6,12 @@ import (
 	"github.com/yl2chen/cidranger"
 )
 
-// IgnoreRanger is a cidranger
-var IgnoreRanger = cidranger.NewPCTrieRanger()
-
-func init() {
+// NewIgnoreRanger create a cidranger of destinations which should not be proxied
+func NewIgnoreRanger() cidranger.Ranger {
+	ranger := cidranger.NewPCTrieRanger()
 	// @see https://tools.ietf.org/html/rfc1112
 	// multicast 224.0.0.0/4 case too many unclosed tcp and udp connections, see `netstat -an | grep '127.0.0.1'`
 	_, rfc1112Network, _ := net.ParseCIDR("224.0.0.0/4")
-	IgnoreRanger.Insert(cidranger.NewBasicRangerEntry(*rfc1112Network))
+	ranger.Insert(cidranger.NewBasicRangerEntry(*rfc1112Network))
+	return ranger
 }
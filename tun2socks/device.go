@@ -0,0 +1,60 @@
+package tun2socks
+
+import (
+	"github.com/FlowerWrong/water"
+)
+
+// Device is a packet device, every Read and Write is a whole ip packet
+type Device interface {
+	Read(packet []byte) (int, error)
+	Write(packet []byte) (int, error)
+	Close() error
+	// Name of the device, eg: tun0
+	Name() string
+	// MTU of the device
+	MTU() uint32
+	// Fd return the file descriptor of the device, -1 means it has no fd,
+	// then the netstack will read and write packets through Read and Write.
+	Fd() int
+}
+
+// multiQueueDevice is a Device with extra queues, every queue is read by its own dispatcher in parallel
+type multiQueueDevice interface {
+	Device
+	// QueueFds return the fds of the extra queues
+	QueueFds() []int
+}
+
+// tunDevice is a tun interface created by water
+type tunDevice struct {
+	*water.Interface
+	mtu    uint32
+	queues []*water.Interface // extra queues of a multi-queue tun
+}
+
+// NewTunDevice create a Device from a water tun interface and the extra queues of it if it is multi-queue
+func NewTunDevice(ifce *water.Interface, mtu uint32, queues ...*water.Interface) Device {
+	return &tunDevice{Interface: ifce, mtu: mtu, queues: queues}
+}
+
+// QueueFds of tun device
+func (d *tunDevice) QueueFds() []int {
+	fds := make([]int, 0, len(d.queues))
+	for _, q := range d.queues {
+		fds = append(fds, q.Fd())
+	}
+	return fds
+}
+
+// Close the extra queues and the tun
+func (d *tunDevice) Close() error {
+	for _, q := range d.queues {
+		q.Close()
+	}
+	return d.Interface.Close()
+}
+
+// MTU of tun device
+func (d *tunDevice) MTU() uint32 {
+	return d.mtu
+}
@@ -0,0 +1,38 @@
+package tun2socks
+
+import (
+	"os"
+)
+
+// fdDevice is a pre-opened packet file descriptor, eg: a tun fd from android VpnService
+type fdDevice struct {
+	*os.File
+	fd   int
+	name string
+	mtu  uint32
+}
+
+// NewFdDevice create a Device from an opened file descriptor, the Device owns the fd
+func NewFdDevice(fd int, name string, mtu uint32) Device {
+	return &fdDevice{
+		File: os.NewFile(uintptr(fd), name),
+		fd:   fd,
+		name: name,
+		mtu:  mtu,
+	}
+}
+
+// Name of fd device
+func (d *fdDevice) Name() string {
+	return d.name
+}
+
+// MTU of fd device
+func (d *fdDevice) MTU() uint32 {
+	return d.mtu
+}
+
+// Fd of fd device
+func (d *fdDevice) Fd() int {
+	return d.fd
+}
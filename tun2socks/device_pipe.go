@@ -0,0 +1,76 @@
+package tun2socks
+
+import (
+	"io"
+	"sync"
+)
+
+// pipe is shared by the two ends of a pipe device
+type pipe struct {
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// pipeDevice is an in-memory Device, packets written to one end are read from the other end
+type pipeDevice struct {
+	name string
+	mtu  uint32
+	in   chan []byte
+	out  chan []byte
+	pipe *pipe
+}
+
+// NewPipeDevice create a pair of connected in-memory devices, eg: one for the netstack and one for tests.
+// Closing either end closes both.
+func NewPipeDevice(name string, mtu uint32) (Device, Device) {
+	p := &pipe{closed: make(chan struct{})}
+	a2b := make(chan []byte, PktChannelSize)
+	b2a := make(chan []byte, PktChannelSize)
+	return &pipeDevice{name: name, mtu: mtu, in: b2a, out: a2b, pipe: p},
+		&pipeDevice{name: name, mtu: mtu, in: a2b, out: b2a, pipe: p}
+}
+
+// Read a packet written by the other end
+func (d *pipeDevice) Read(packet []byte) (int, error) {
+	select {
+	case pkt := <-d.in:
+		return copy(packet, pkt), nil
+	case <-d.pipe.closed:
+		return 0, io.EOF
+	}
+}
+
+// Write a packet to the other end
+func (d *pipeDevice) Write(packet []byte) (int, error) {
+	pkt := make([]byte, len(packet))
+	copy(pkt, packet)
+	select {
+	case d.out <- pkt:
+		return len(packet), nil
+	case <-d.pipe.closed:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+// Close both ends of the pipe
+func (d *pipeDevice) Close() error {
+	d.pipe.closeOnce.Do(func() {
+		close(d.pipe.closed)
+	})
+	return nil
+}
+
+// Name of pipe device
+func (d *pipeDevice) Name() string {
+	return d.name
+}
+
+// MTU of pipe device
+func (d *pipeDevice) MTU() uint32 {
+	return d.mtu
+}
+
+// Fd of pipe device, it has no fd
+func (d *pipeDevice) Fd() int {
+	return -1
+}
@@ -7,19 +7,23 @@ import (
 // ServeDNS ...
 func (app *App) ServeDNS() error {
 	if app.Cfg.DNS.AutoConfigSystemDNS {
-		app.SetAndResetSystemDNSServers(true)
+		app.configSystemDNS()
+	}
+	if pc := app.FakeDNS.Server.PacketConn; pc != nil {
+		log.Printf("[dns] listen on %s", pc.LocalAddr())
+		return app.FakeDNS.Server.ActivateAndServe()
 	}
 	log.Printf("[dns] listen on %s", app.FakeDNS.Server.Addr)
+	// the conn is closed when serving returns, the next Run listens again
+	defer func() {
+		app.FakeDNS.Server.PacketConn = nil
+	}()
 	return app.FakeDNS.Server.ListenAndServe()
 }
 
-// StopDNS ...
+// StopDNS stop accepting dns queries, system dns is restored by the shutdown after tunnels drained
 func (app *App) StopDNS() error {
-	<-QuitDNS
 	log.Println("quit dns")
-	if app.Cfg.DNS.AutoConfigSystemDNS {
-		app.SetAndResetSystemDNSServers(false)
-	}
 	err := app.FakeDNS.Server.Shutdown()
 	if err != nil {
 		log.Println(err)
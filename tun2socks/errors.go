@@ -0,0 +1,67 @@
+package tun2socks
+
+import "fmt"
+
+// ConfigError is returned when a config file can not be loaded
+type ConfigError struct {
+	File string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("config %s: %v", e.File, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// TunError is returned when the tun interface can not be created or configured
+type TunError struct {
+	Op  string // create, ifconfig
+	Err error
+}
+
+func (e *TunError) Error() string {
+	return fmt.Sprintf("tun %s: %v", e.Op, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *TunError) Unwrap() error { return e.Err }
+
+// NetstackError is returned when the tcp/ip stack can not be set up
+type NetstackError struct {
+	Op  string // parse address, pick port, new link endpoint, create nic, add address
+	Err error
+}
+
+func (e *NetstackError) Error() string {
+	return fmt.Sprintf("netstack %s: %v", e.Op, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *NetstackError) Unwrap() error { return e.Err }
+
+// ProxyError is returned when the proxies can not be set up from config
+type ProxyError struct {
+	Err error
+}
+
+func (e *ProxyError) Error() string {
+	return fmt.Sprintf("proxy: %v", e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *ProxyError) Unwrap() error { return e.Err }
+
+// PrivilegeError is returned when the privileges can not be dropped to User
+type PrivilegeError struct {
+	User string
+	Err  error
+}
+
+func (e *PrivilegeError) Error() string {
+	return fmt.Sprintf("drop privileges to %q: %v", e.User, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *PrivilegeError) Unwrap() error { return e.Err }
@@ -0,0 +1,23 @@
+package tun2socks
+
+import (
+	"context"
+	"errors"
+)
+
+var errExec = errors.New("exec is only supported on linux")
+
+// Exec is not supported
+func (app *App) Exec(ctx context.Context, configFile string, argv []string) (int, error) {
+	return -1, errExec
+}
+
+// IsExecChild is always false
+func IsExecChild() bool {
+	return false
+}
+
+// ExecChild is not supported
+func ExecChild(argv []string) error {
+	return errExec
+}
@@ -0,0 +1,204 @@
+package tun2socks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/FlowerWrong/tun2socks/util"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	execChildEnv  = "TUN2SOCKS_EXEC_CHILD"  // set for the re-executed child which joins the namespace
+	execResolvEnv = "TUN2SOCKS_EXEC_RESOLV" // resolv.conf of the namespace
+	execNetnsFd   = 3                       // the namespace is passed to the child as the first extra file
+	execDNSAddr   = "127.0.0.1:53"          // fake dns listen address inside the namespace
+)
+
+var errExecCanceled = errors.New("tun2socks stopped before the command started")
+
+// Exec run argv in a new network namespace which is routed through tun2socks until it exits.
+// The tun is moved into the namespace and is the default route there, the fake dns listens on 127.0.0.1:53
+// inside and is the only nameserver of the command, so dns-mode must be fake. The host routes and dns are untouched.
+// It return the exit code of argv.
+func (app *App) Exec(ctx context.Context, configFile string, argv []string) (int, error) {
+	if len(argv) == 0 {
+		return -1, errors.New("no command to exec")
+	}
+	ctx, err := app.begin(ctx)
+	if err != nil {
+		return -1, err
+	}
+	defer app.end()
+
+	if err := app.Config(configFile); err != nil {
+		return -1, err
+	}
+	// the command resolves by the fake dns inside the namespace, there is no other nameserver
+	if app.Cfg.DNS.DNSMode != FakeMode {
+		return -1, &ConfigError{File: configFile, Err: fmt.Errorf("dns-mode %q is not supported by exec, use %q", app.Cfg.DNS.DNSMode, FakeMode)}
+	}
+	// host dns and routes are not managed in exec mode
+	app.Cfg.DNS.AutoConfigSystemDNS = false
+	app.Cfg.Route.AutoRoute = false
+	app.Cfg.Route.KillSwitch = false
+	app.Cfg.Route.UID, app.Cfg.Route.Cgroup = nil, nil
+	app.fdMode = true
+
+	ns, err := util.NewNetns()
+	if err != nil {
+		return -1, &TunError{Op: "create netns", Err: err}
+	}
+	defer ns.Close()
+
+	dev, err := createTun(app.Cfg.General)
+	if err != nil {
+		return -1, err
+	}
+	ip, ipNet, _ := net.ParseCIDR(app.Cfg.General.Network)
+	if err := util.SetupLinkInNetns(dev.Name(), ns, &net.IPNet{IP: ip.To4(), Mask: ipNet.Mask}, int(app.Cfg.General.Mtu)); err != nil {
+		dev.Close()
+		return -1, &TunError{Op: "move to netns", Err: err}
+	}
+	app.Dev = dev
+
+	err = util.InNetns(ns, func() error {
+		pc, err := net.ListenPacket("udp", execDNSAddr)
+		if err == nil {
+			app.FakeDNS.Server.PacketConn = pc
+		}
+		return err
+	})
+	if err != nil {
+		dev.Close()
+		return -1, &ConfigError{File: configFile, Err: fmt.Errorf("fake dns in netns: %v", err)}
+	}
+
+	resolv, err := ioutil.TempFile("", "tun2socks-resolv")
+	if err != nil {
+		// run is not started, which would serve and close the dns conn
+		app.FakeDNS.Server.PacketConn.Close()
+		app.FakeDNS.Server.PacketConn = nil
+		dev.Close()
+		return -1, err
+	}
+	defer os.Remove(resolv.Name())
+	host, _, _ := net.SplitHostPort(execDNSAddr)
+	fmt.Fprintf(resolv, "nameserver %s\n", host)
+	resolv.Close()
+
+	// the command is started only when tun2socks is serving
+	ready := make(chan struct{})
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- app.run(ctx, ready)
+	}()
+	select {
+	case <-ready:
+	case err := <-runErr:
+		if err == nil {
+			err = errExecCanceled
+		}
+		return -1, err
+	}
+
+	code, err := runInNetns(ns, resolv.Name(), argv)
+	app.Stop()
+	if rerr := <-runErr; err == nil {
+		err = rerr
+	}
+	return code, err
+}
+
+// runInNetns re-execute tun2socks as a child in a private mount namespace, the child joins ns,
+// mounts resolv over /etc/resolv.conf and executes argv, see ExecChild
+func runInNetns(ns netns.NsHandle, resolv string, argv []string) (int, error) {
+	// a dup of ns, ns is closed by the caller
+	fd, err := unix.Dup(int(ns))
+	if err != nil {
+		return -1, err
+	}
+	nsFile := os.NewFile(uintptr(fd), "netns")
+	defer nsFile.Close()
+
+	cmd := exec.Command("/proc/self/exe", argv...)
+	cmd.Args[0] = os.Args[0]
+	cmd.Env = append(os.Environ(), execChildEnv+"=1", execResolvEnv+"="+resolv)
+	cmd.ExtraFiles = []*os.File{nsFile}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Unshareflags: syscall.CLONE_NEWNS}
+
+	if err := cmd.Start(); err != nil {
+		return -1, err
+	}
+
+	// the command decides how to quit on signals, tun2socks quits after it
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(c)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case s := <-c:
+				cmd.Process.Signal(s)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+		if status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return status.ExitStatus(), nil
+	}
+	return -1, err
+}
+
+// IsExecChild report whether this process is the child re-executed by Exec
+func IsExecChild() bool {
+	return os.Getenv(execChildEnv) != ""
+}
+
+// ExecChild join the network namespace, mount the resolv.conf and execute argv, it only returns on error
+func ExecChild(argv []string) error {
+	runtime.LockOSThread()
+	if err := unix.Setns(execNetnsFd, unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("join netns: %v", err)
+	}
+	unix.Close(execNetnsFd)
+
+	// mounts of this private mount namespace must not propagate to the host
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_SLAVE, ""); err != nil {
+		return fmt.Errorf("make / slave: %v", err)
+	}
+	if err := unix.Mount(os.Getenv(execResolvEnv), "/etc/resolv.conf", "", unix.MS_BIND, ""); err != nil {
+		return fmt.Errorf("mount resolv.conf: %v", err)
+	}
+
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return err
+	}
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, execChildEnv+"=") && !strings.HasPrefix(kv, execResolvEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	return unix.Exec(path, argv, env)
+}
@@ -0,0 +1,36 @@
+package tun2socks
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecDNSMode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tun2socks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	configFile := filepath.Join(dir, "config.yaml")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(`
+dns:
+  dns-mode: udp_relay_via_socks5
+proxy:
+  A:
+    url: socks5://127.0.0.1:1080
+`), 0644))
+
+	// only the fake dns can be the nameserver inside the namespace, nothing is created before the check
+	app := new(App)
+	code, err := app.Exec(context.Background(), configFile, []string{"true"})
+	assert.Equal(t, -1, code)
+	_, ok := err.(*ConfigError)
+	assert.True(t, ok, "%v", err)
+	assert.Contains(t, err.Error(), `dns-mode "udp_relay_via_socks5" is not supported by exec`)
+	assert.Nil(t, app.Dev)
+	assert.False(t, app.running())
+}
@@ -0,0 +1,324 @@
+package tun2socks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testNetwork = "198.18.0.0/15"
+	testTimeout = 5 * time.Second
+)
+
+// testLocalIP is the address of local applications, eg: the kernel side of the tun
+var testLocalIP = net.IPv4(198, 18, 0, 2).To4()
+
+// upstream dns answer for every A query
+var testUpstreamIP = net.IPv4(1, 2, 3, 4).To4()
+
+const testConfig = `
+[general]
+network = %s
+mtu = 1500
+grace-period = 1
+
+[dns]
+dns-port = %d
+nameserver = %s
+auto-config-system-dns = false
+
+[tcp]
+timeout = 5
+
+[udp]
+enabled = true
+timeout = 5
+proxy = A
+
+[proxy "A"]
+url = socks5://%s
+default = yes
+
+[pattern "proxy-example"]
+proxy = A
+scheme = DOMAIN-SUFFIX
+v = example.com
+
+[rule]
+pattern = proxy-example
+`
+
+// testEnv runs an App on an in-memory pipe device, with a local socks5 server,
+// tcp and udp echo servers as socks5 targets and a stub upstream dns server. No root or network is needed.
+type testEnv struct {
+	t       *testing.T
+	app     *App
+	dev     Device // the other end of app.Dev
+	dnsAddr string
+	socks   *socks5Server
+	dir     string
+	closers []io.Closer
+	packets chan gopacket.Packet
+	cancel  context.CancelFunc
+	done    chan error
+	stopped bool
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	env := &testEnv{
+		t:       t,
+		packets: make(chan gopacket.Packet, 64),
+		done:    make(chan error, 1),
+	}
+
+	tcpEcho := env.startTCPEcho()
+	udpEcho := env.startUDPEcho()
+	upstream := env.startUpstreamDNS()
+	env.socks = newSocks5Server(t, tcpEcho, udpEcho)
+	env.closers = append(env.closers, env.socks)
+
+	dnsPort := freeUDPPort(t)
+	env.dnsAddr = fmt.Sprintf("127.0.0.1:%d", dnsPort)
+
+	dir, err := ioutil.TempDir("", "tun2socks")
+	require.NoError(t, err)
+	env.dir = dir
+	configFile := filepath.Join(dir, "config.ini")
+	content := fmt.Sprintf(testConfig, testNetwork, dnsPort, upstream, env.socks.Addr())
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(content), 0644))
+
+	env.app = new(App)
+	require.NoError(t, env.app.Config(configFile))
+	env.start()
+	return env
+}
+
+// start run the app on a new pipe device and wait the fake dns to be ready
+func (env *testEnv) start() {
+	env.app.Dev, env.dev = NewPipeDevice("pipe0", env.app.Cfg.General.Mtu)
+	go env.readPackets(env.dev)
+
+	var ctx context.Context
+	ctx, env.cancel = context.WithCancel(context.Background())
+	env.stopped = false
+	go func() {
+		env.done <- env.app.Run(ctx)
+	}()
+	env.waitReady()
+}
+
+// stop the app and wait it to return
+func (env *testEnv) stop() {
+	if env.stopped {
+		return
+	}
+	env.stopped = true
+	env.cancel()
+	select {
+	case err := <-env.done:
+		require.NoError(env.t, err)
+	case <-time.After(testTimeout):
+		env.t.Error("app did not stop in time")
+	}
+}
+
+// Close stop the app and all the servers
+func (env *testEnv) Close() {
+	env.stop()
+	for _, c := range env.closers {
+		c.Close()
+	}
+	os.RemoveAll(env.dir)
+}
+
+func (env *testEnv) waitReady() {
+	deadline := time.Now().Add(testTimeout)
+	client := &dns.Client{Net: "udp", Timeout: 100 * time.Millisecond}
+	for time.Now().Before(deadline) {
+		if _, _, err := client.Exchange(queryA("ready.test"), env.dnsAddr); err == nil {
+			return
+		}
+	}
+	env.t.Fatal("fake dns is not ready")
+}
+
+// lookup domain from the fake dns server
+func (env *testEnv) lookup(domain string) net.IP {
+	client := &dns.Client{Net: "udp", Timeout: testTimeout}
+	msg, _, err := client.Exchange(queryA(domain), env.dnsAddr)
+	require.NoError(env.t, err)
+	for _, rr := range msg.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			return a.A.To4()
+		}
+	}
+	env.t.Fatalf("no A record for %s", domain)
+	return nil
+}
+
+func queryA(domain string) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(domain), dns.TypeA)
+	return msg
+}
+
+// readPackets parse every packet the app writes to dev until it is closed
+func (env *testEnv) readPackets(dev Device) {
+	for {
+		buf := make([]byte, BuffSize)
+		n, err := dev.Read(buf)
+		if err != nil {
+			return
+		}
+		env.packets <- gopacket.NewPacket(buf[:n], layers.LayerTypeIPv4, gopacket.Default)
+	}
+}
+
+// expectPacket return the first packet matched, other packets are dropped
+func (env *testEnv) expectPacket(match func(gopacket.Packet) bool) gopacket.Packet {
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case pkt := <-env.packets:
+			if match(pkt) {
+				return pkt
+			}
+		case <-timeout:
+			env.t.Fatal("expected packet not received")
+			return nil
+		}
+	}
+}
+
+// expectTCP return the first tcp segment from src:srcPort to testLocalIP:dstPort matched
+func (env *testEnv) expectTCP(src net.IP, srcPort, dstPort uint16, match func(*layers.TCP) bool) *layers.TCP {
+	pkt := env.expectPacket(func(pkt gopacket.Packet) bool {
+		ip, _ := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		tcp, _ := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		return ip != nil && tcp != nil && ip.SrcIP.Equal(src) && ip.DstIP.Equal(testLocalIP) &&
+			uint16(tcp.SrcPort) == srcPort && uint16(tcp.DstPort) == dstPort && match(tcp)
+	})
+	return pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+}
+
+// expectUDP return the first udp datagram from src:srcPort to testLocalIP:dstPort
+func (env *testEnv) expectUDP(src net.IP, srcPort, dstPort uint16) *layers.UDP {
+	pkt := env.expectPacket(func(pkt gopacket.Packet) bool {
+		ip, _ := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+		udp, _ := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		return ip != nil && udp != nil && ip.SrcIP.Equal(src) && ip.DstIP.Equal(testLocalIP) &&
+			uint16(udp.SrcPort) == srcPort && uint16(udp.DstPort) == dstPort
+	})
+	return pkt.Layer(layers.LayerTypeUDP).(*layers.UDP)
+}
+
+// writeTCP inject a tcp segment from testLocalIP to dst
+func (env *testEnv) writeTCP(dst net.IP, tcp *layers.TCP, payload []byte) {
+	env.writeIPv4(dst, layers.IPProtocolTCP, tcp, payload)
+}
+
+// writeUDP inject a udp datagram from testLocalIP to dst
+func (env *testEnv) writeUDP(dst net.IP, udp *layers.UDP, payload []byte) {
+	env.writeIPv4(dst, layers.IPProtocolUDP, udp, payload)
+}
+
+func (env *testEnv) writeIPv4(dst net.IP, proto layers.IPProtocol, transport gopacket.SerializableLayer, payload []byte) {
+	ip := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: proto,
+		SrcIP:    testLocalIP,
+		DstIP:    dst,
+	}
+	switch l := transport.(type) {
+	case *layers.TCP:
+		require.NoError(env.t, l.SetNetworkLayerForChecksum(ip))
+	case *layers.UDP:
+		require.NoError(env.t, l.SetNetworkLayerForChecksum(ip))
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(env.t, gopacket.SerializeLayers(buf, opts, ip, transport, gopacket.Payload(payload)))
+	_, err := env.dev.Write(buf.Bytes())
+	require.NoError(env.t, err)
+}
+
+func (env *testEnv) startTCPEcho() string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(env.t, err)
+	env.closers = append(env.closers, ln)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func (env *testEnv) startUDPEcho() string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(env.t, err)
+	env.closers = append(env.closers, conn)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// startUpstreamDNS answer testUpstreamIP for every A query
+func (env *testEnv) startUpstreamDNS() string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(env.t, err)
+	server := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			rsp := new(dns.Msg)
+			rsp.SetReply(r)
+			if r.Question[0].Qtype == dns.TypeA {
+				rsp.Answer = append(rsp.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+					A:   testUpstreamIP,
+				})
+			}
+			w.WriteMsg(rsp)
+		}),
+	}
+	go server.ActivateAndServe()
+	env.closers = append(env.closers, pc)
+	return pc.LocalAddr().String()
+}
+
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
@@ -0,0 +1,61 @@
+package tun2socks
+
+import (
+	"log"
+
+	"github.com/FlowerWrong/tun2socks/util"
+)
+
+// SetupKillSwitch drop the output which does not go through the tun, see util.KillSwitch.
+// The proxy servers and the sockets marked with route.fwmark are allowed.
+func (app *App) SetupKillSwitch() error {
+	ks := &util.KillSwitch{
+		Tun:   app.Dev.Name(),
+		Mark:  app.Cfg.Route.FWMark,
+		Allow: app.proxyNets(),
+	}
+	if err := ks.Install(); err != nil {
+		return &TunError{Op: "kill switch", Err: err}
+	}
+	log.Printf("[route] kill switch on, only %s, lo and %d proxy servers can send", ks.Tun, len(ks.Allow))
+
+	app.mu.Lock()
+	app.killSwitch = ks
+	app.mu.Unlock()
+	return nil
+}
+
+// reloadKillSwitch allow the proxy servers of the reloaded config
+func (app *App) reloadKillSwitch() {
+	app.mu.Lock()
+	ks := app.killSwitch
+	app.mu.Unlock()
+	if ks == nil {
+		return
+	}
+	ks.Allow = app.proxyNets()
+	if err := ks.Install(); err != nil {
+		log.Println("[route] reload kill switch failed", err)
+	}
+}
+
+// removeKillSwitch delete the kill switch rules, only a clean shutdown does it
+func (app *App) removeKillSwitch() (bool, error) {
+	app.mu.Lock()
+	ks := app.killSwitch
+	app.killSwitch = nil
+	app.mu.Unlock()
+	if ks == nil {
+		return false, nil
+	}
+	if err := ks.Remove(); err != nil {
+		log.Println("[route] remove kill switch failed", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// KillSwitchOff remove the kill switch left by a crashed tun2socks
+func KillSwitchOff() error {
+	return new(util.KillSwitch).Remove()
+}
@@ -0,0 +1,71 @@
+package tun2socks
+
+import (
+	"sync"
+
+	"github.com/FlowerWrong/netstack/tcpip"
+	"github.com/FlowerWrong/netstack/tcpip/buffer"
+	"github.com/FlowerWrong/netstack/tcpip/header"
+	"github.com/FlowerWrong/netstack/tcpip/network/ipv4"
+	"github.com/FlowerWrong/netstack/tcpip/stack"
+	"github.com/FlowerWrong/netstack/tcpip/transport/udp"
+	"github.com/FlowerWrong/tun2socks/util"
+)
+
+// natEndpoint wraps a link endpoint, it records the original destination of every inbound udp packet
+// into nat before the packet is delivered to the netstack, because the hooked udp endpoint only knows the source.
+// The mss of inbound tcp syn is clamped too if mss is not 0.
+type natEndpoint struct {
+	stack.LinkEndpoint
+	dispatcher stack.NetworkDispatcher
+	nat        *sync.Map // local port -> stack.TransportEndpointID
+	mss        uint16
+}
+
+// newNatEndpoint wrap the link endpoint of lower, then register it
+func newNatEndpoint(lower tcpip.LinkEndpointID, nat *sync.Map, mss uint16) (tcpip.LinkEndpointID, *natEndpoint) {
+	e := &natEndpoint{
+		LinkEndpoint: stack.FindLinkEndpoint(lower),
+		nat:          nat,
+		mss:          mss,
+	}
+	return stack.RegisterLinkEndpoint(e), e
+}
+
+// Attach implements stack.LinkEndpoint
+func (e *natEndpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.dispatcher = dispatcher
+	e.LinkEndpoint.Attach(e)
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher
+func (e *natEndpoint) DeliverNetworkPacket(linkEP stack.LinkEndpoint, remoteLinkAddr tcpip.LinkAddress, protocol tcpip.NetworkProtocolNumber, vv buffer.VectorisedView) {
+	if protocol == ipv4.ProtocolNumber {
+		e.record(vv.First())
+		if e.mss != 0 {
+			util.ClampMSS(vv.First(), e.mss)
+		}
+	}
+	e.dispatcher.DeliverNetworkPacket(e, remoteLinkAddr, protocol, vv)
+}
+
+func (e *natEndpoint) record(v buffer.View) {
+	if len(v) < header.IPv4MinimumSize {
+		return
+	}
+	ip := header.IPv4(v)
+	if ip.Protocol() != uint8(udp.ProtocolNumber) {
+		return
+	}
+	hlen := int(ip.HeaderLength())
+	if len(v) < hlen+header.UDPMinimumSize {
+		return
+	}
+	u := header.UDP(v[hlen:])
+	e.nat.Store(u.SourcePort(), stack.TransportEndpointID{
+		LocalPort:     u.DestinationPort(),
+		LocalAddress:  ip.DestinationAddress(),
+		RemotePort:    u.SourcePort(),
+		RemoteAddress: ip.SourceAddress(),
+	})
+}
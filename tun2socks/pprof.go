@@ -1,6 +1,7 @@
 package tun2socks
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,17 +10,18 @@ import (
 
 // ServePprof ...
 func (app *App) ServePprof() error {
-	pprofAddr := fmt.Sprintf("%s:%d", app.Cfg.Pprof.ProfHost, app.Cfg.Pprof.ProfPort)
-	app.Pprof = &http.Server{Addr: pprofAddr}
-	log.Println("[pprof] Http pprof listen on", pprofAddr, " see", fmt.Sprintf("http://%s/debug/pprof/", pprofAddr))
-	return app.Pprof.ListenAndServe()
+	log.Println("[pprof] Http pprof listen on", app.Pprof.Addr, " see", fmt.Sprintf("http://%s/debug/pprof/", app.Pprof.Addr))
+	err := app.Pprof.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
 }
 
 // StopPprof ...
 func (app *App) StopPprof() error {
-	<-QuitPprof
 	log.Println("quit http pprof")
-	err := app.Pprof.Shutdown(nil)
+	err := app.Pprof.Shutdown(context.Background())
 	if err != nil {
 		log.Println(err)
 	}
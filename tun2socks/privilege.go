@@ -0,0 +1,51 @@
+package tun2socks
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/FlowerWrong/tun2socks/util"
+)
+
+// DropPrivileges switch to general.user, default the sudo user, only CAP_NET_ADMIN is kept for routes and reload.
+// The fake dns is bound and the system dns is configured before, they need root.
+func (app *App) DropPrivileges() error {
+	name := app.Cfg.General.User
+	if name == "" {
+		name = os.Getenv("SUDO_USER")
+	}
+	if name == "" {
+		return &PrivilegeError{Err: errors.New("no user, set general.user or run by sudo")}
+	}
+
+	if app.Cfg.DNS.DNSMode == FakeMode && app.FakeDNS.Server.PacketConn == nil {
+		pc, err := net.ListenPacket("udp", app.FakeDNS.Server.Addr)
+		if err != nil {
+			return &PrivilegeError{User: name, Err: fmt.Errorf("bind dns before: %v", err)}
+		}
+		app.FakeDNS.Server.PacketConn = pc
+		if app.Cfg.DNS.AutoConfigSystemDNS {
+			app.configSystemDNS()
+		}
+	}
+
+	if err := util.DropPrivileges(name); err != nil {
+		return &PrivilegeError{User: name, Err: err}
+	}
+	log.Printf("[app] privileges dropped to %s, CAP_NET_ADMIN is kept", name)
+	return nil
+}
+
+// configSystemDNS set the system dns to the fake dns once, it is restored on shutdown
+func (app *App) configSystemDNS() {
+	app.mu.Lock()
+	set := app.sysDNS
+	app.sysDNS = true
+	app.mu.Unlock()
+	if !set {
+		app.SetAndResetSystemDNSServers(true)
+	}
+}
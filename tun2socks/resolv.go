@@ -0,0 +1,87 @@
+package tun2socks
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/FlowerWrong/tun2socks/util"
+)
+
+const resolvConfPath = "/etc/resolv.conf"
+
+// setLinuxDNS point the system dns to the fake dns. With systemd-resolved, the dns of the tun link is set
+// by resolvectl, otherwise /etc/resolv.conf is backed up and replaced, see util.ResolvConf.
+func (app *App) setLinuxDNS() error {
+	if systemdResolved() {
+		ip, _, _ := net.ParseCIDR(app.Cfg.General.Network)
+		server := ip.String()
+		if app.Cfg.DNS.DNSPort != 53 {
+			server = fmt.Sprintf("%s:%d", server, app.Cfg.DNS.DNSPort)
+		}
+		tun := app.Dev.Name()
+		if err := util.ExecCommand("resolvectl", fmt.Sprintf("dns %s %s", tun, server)); err != nil {
+			return fmt.Errorf("resolvectl dns: %v", err)
+		}
+		// route all the domains to the tun link
+		if err := util.ExecCommand("resolvectl", fmt.Sprintf("domain %s ~.", tun)); err != nil {
+			util.ExecCommand("resolvectl", "revert "+tun)
+			return fmt.Errorf("resolvectl domain: %v", err)
+		}
+		app.resolvectl = tun
+		return nil
+	}
+
+	rc := util.NewResolvConf(resolvConfPath)
+	if rc.HasBackup() {
+		log.Printf("[dns] %s is left by a crashed run, it is kept as the original", rc.Backup)
+	}
+	if err := rc.Set("127.0.0.1"); err != nil {
+		return err
+	}
+	app.resolvConf = rc
+	return nil
+}
+
+// restoreLinuxDNS revert the dns of the tun link or restore /etc/resolv.conf
+func (app *App) restoreLinuxDNS() error {
+	if tun := app.resolvectl; tun != "" {
+		app.resolvectl = ""
+		return util.ExecCommand("resolvectl", "revert "+tun)
+	}
+	if rc := app.resolvConf; rc != nil {
+		app.resolvConf = nil
+		return rc.Restore()
+	}
+	return nil
+}
+
+// recoverResolvConf restore /etc/resolv.conf left by a crashed tun2socks
+func recoverResolvConf() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	rc := util.NewResolvConf(resolvConfPath)
+	if !rc.HasBackup() {
+		return
+	}
+	if err := rc.Restore(); err != nil {
+		log.Printf("[dns] restore %s left by a crashed run failed: %v", rc.Backup, err)
+		return
+	}
+	log.Printf("[dns] %s left by a crashed run is restored", rc.Backup)
+}
+
+// systemdResolved report whether /etc/resolv.conf is managed by systemd-resolved and resolvectl is there
+func systemdResolved() bool {
+	target, err := os.Readlink(resolvConfPath)
+	if err != nil || !strings.Contains(target, "/run/systemd/resolve/") {
+		return false
+	}
+	_, err = exec.LookPath("resolvectl")
+	return err == nil
+}
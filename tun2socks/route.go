@@ -0,0 +1,170 @@
+package tun2socks
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/FlowerWrong/tun2socks/util"
+)
+
+// RouteResult is the result of adding or deleting a route
+type RouteResult struct {
+	Route string
+	Op    string // add or delete
+	Err   error
+}
+
+// RouteManager installs routes to the tun and records them,
+// so a reload only adds new routes and deletes dropped ones, and all of them are deleted on exit.
+type RouteManager struct {
+	add func(tun string, routes []string) []error // errs[i] is the result of routes[i]
+	del func(tun string, routes []string) []error
+
+	mu        sync.Mutex
+	installed map[string]string // route -> tun
+}
+
+// NewRouteManager create a RouteManager with the system route table
+func NewRouteManager() *RouteManager {
+	return &RouteManager{
+		add:       util.AddRoutes,
+		del:       util.DelRoutes,
+		installed: make(map[string]string),
+	}
+}
+
+// Sync make the installed routes on tun equal to routes, routes are subnets or hosts.
+// Failed adds are retried by the next Sync, failed deletes are kept and retried by the next Sync or Clear.
+func (m *RouteManager) Sync(tun string, routes []string) []RouteResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	want := make(map[string]bool)
+	var adds []string
+	for _, route := range routes {
+		route = normalizeRoute(route)
+		if want[route] {
+			continue
+		}
+		want[route] = true
+		if _, ok := m.installed[route]; !ok {
+			adds = append(adds, route)
+		}
+	}
+	var dels []string
+	for _, route := range m.sortedInstalled() {
+		if !want[route] {
+			dels = append(dels, route)
+		}
+	}
+
+	results := m.apply(tun, adds, dels)
+	logRouteFailures(results)
+	return results
+}
+
+// Clear delete all the installed routes
+func (m *RouteManager) Clear() []RouteResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := m.apply("", nil, m.sortedInstalled())
+	logRouteFailures(results)
+	return results
+}
+
+// Installed return the installed routes
+func (m *RouteManager) Installed() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sortedInstalled()
+}
+
+// apply add routes to tun and delete routes from the tun they were added to, in batches
+func (m *RouteManager) apply(tun string, adds, dels []string) []RouteResult {
+	var results []RouteResult
+	if len(adds) > 0 {
+		for i, err := range m.add(tun, adds) {
+			results = append(results, RouteResult{Route: adds[i], Op: "add", Err: err})
+			if err == nil {
+				m.installed[adds[i]] = tun
+			}
+		}
+	}
+
+	byTun := make(map[string][]string)
+	var tuns []string
+	for _, route := range dels {
+		t := m.installed[route]
+		if _, ok := byTun[t]; !ok {
+			tuns = append(tuns, t)
+		}
+		byTun[t] = append(byTun[t], route)
+	}
+	for _, t := range tuns {
+		routes := byTun[t]
+		for i, err := range m.del(t, routes) {
+			results = append(results, RouteResult{Route: routes[i], Op: "delete", Err: err})
+			if err == nil {
+				delete(m.installed, routes[i])
+			}
+		}
+	}
+	return results
+}
+
+func (m *RouteManager) sortedInstalled() []string {
+	routes := make([]string, 0, len(m.installed))
+	for route := range m.installed {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+// routeSummary counts the results of a Sync
+type routeSummary struct {
+	added, deleted, failed int
+	err                    error // the first failure
+}
+
+func summarizeRoutes(results []RouteResult) routeSummary {
+	var s routeSummary
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			s.failed++
+			if s.err == nil {
+				s.err = fmt.Errorf("%s route %s: %v", r.Op, r.Route, r.Err)
+			}
+		case r.Op == "add":
+			s.added++
+		default:
+			s.deleted++
+		}
+	}
+	return s
+}
+
+func (s routeSummary) String() string {
+	return fmt.Sprintf("%d added, %d deleted, %d failed", s.added, s.deleted, s.failed)
+}
+
+func logRouteFailures(results []RouteResult) {
+	for _, r := range results {
+		if r.Err != nil {
+			log.Printf("[route] %s route %s failed: %v", r.Op, r.Route, r.Err)
+		}
+	}
+}
+
+// normalizeRoute return the subnet of a cidr, eg: 10.1.2.3/8 -> 10.0.0.0/8, hosts are unchanged
+func normalizeRoute(route string) string {
+	if _, subnet, err := net.ParseCIDR(route); err == nil {
+		return subnet.String()
+	}
+	return route
+}
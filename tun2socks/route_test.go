@@ -0,0 +1,98 @@
+package tun2socks
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRouteTable record routes instead of touching the system route table
+type fakeRouteTable struct {
+	routes map[string]string // route -> tun
+	fail   map[string]bool
+}
+
+func newFakeRouteManager() (*RouteManager, *fakeRouteTable) {
+	table := &fakeRouteTable{routes: make(map[string]string), fail: make(map[string]bool)}
+	m := NewRouteManager()
+	m.add = func(tun string, routes []string) []error {
+		errs := make([]error, len(routes))
+		for i, route := range routes {
+			if table.fail[route] {
+				errs[i] = errors.New("add failed")
+				continue
+			}
+			table.routes[route] = tun
+		}
+		return errs
+	}
+	m.del = func(tun string, routes []string) []error {
+		errs := make([]error, len(routes))
+		for i, route := range routes {
+			if table.fail[route] {
+				errs[i] = errors.New("delete failed")
+				continue
+			}
+			delete(table.routes, route)
+		}
+		return errs
+	}
+	return m, table
+}
+
+func TestRouteManagerSync(t *testing.T) {
+	m, table := newFakeRouteManager()
+	table.fail["8.8.8.8"] = true
+
+	results := m.Sync("tun0", []string{"10.1.2.3/8", "10.0.0.0/8", "91.108.4.0/22", "8.8.8.8"})
+	assert.Equal(t, []RouteResult{
+		{Route: "10.0.0.0/8", Op: "add"},
+		{Route: "91.108.4.0/22", Op: "add"},
+		{Route: "8.8.8.8", Op: "add", Err: errors.New("add failed")},
+	}, results)
+	assert.Equal(t, []string{"10.0.0.0/8", "91.108.4.0/22"}, m.Installed())
+
+	// reload: the failed route is retried, dropped routes are deleted, kept routes are untouched
+	delete(table.fail, "8.8.8.8")
+	results = m.Sync("tun0", []string{"8.8.8.8", "91.108.4.0/22", "149.154.160.0/20"})
+	assert.Equal(t, []RouteResult{
+		{Route: "8.8.8.8", Op: "add"},
+		{Route: "149.154.160.0/20", Op: "add"},
+		{Route: "10.0.0.0/8", Op: "delete"},
+	}, results)
+	assert.Equal(t, map[string]string{"8.8.8.8": "tun0", "91.108.4.0/22": "tun0", "149.154.160.0/20": "tun0"}, table.routes)
+}
+
+func TestRouteManagerClear(t *testing.T) {
+	m, table := newFakeRouteManager()
+	m.Sync("tun0", []string{"10.0.0.0/8", "8.8.8.8"})
+
+	// a failed delete is kept and retried
+	table.fail["8.8.8.8"] = true
+	results := m.Clear()
+	assert.Equal(t, []RouteResult{
+		{Route: "10.0.0.0/8", Op: "delete"},
+		{Route: "8.8.8.8", Op: "delete", Err: errors.New("delete failed")},
+	}, results)
+	assert.Equal(t, []string{"8.8.8.8"}, m.Installed())
+
+	delete(table.fail, "8.8.8.8")
+	m.Clear()
+	assert.Empty(t, m.Installed())
+	assert.Empty(t, table.routes)
+}
+
+func TestSummarizeRoutes(t *testing.T) {
+	s := summarizeRoutes([]RouteResult{
+		{Route: "10.0.0.0/8", Op: "add"},
+		{Route: "8.8.8.8", Op: "add", Err: errors.New("add failed")},
+		{Route: "1.1.1.1", Op: "delete"},
+		{Route: "9.9.9.9", Op: "add", Err: errors.New("no such device")},
+	})
+	assert.Equal(t, "1 added, 1 deleted, 2 failed", s.String())
+	assert.EqualError(t, s.err, "add route 8.8.8.8: add failed")
+
+	s = summarizeRoutes(nil)
+	assert.Equal(t, routeSummary{}, s)
+}
@@ -0,0 +1,142 @@
+package tun2socks
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/FlowerWrong/tun2socks/util"
+)
+
+var errShutdown = errors.New("tun2socks shutdown")
+
+// ShutdownReport is what has been cleaned up when tun2socks stopped
+type ShutdownReport struct {
+	DrainedTCP    int // tcp tunnels finished in the grace period
+	DrainedUDP    int // udp tunnels finished in the grace period
+	ClosedTCP     int // tcp tunnels still alive after the grace period and closed
+	ClosedUDP     int // udp tunnels still alive after the grace period and closed
+	DNSRestored   bool
+	RoutesRemoved []string
+	RouteErrors   map[string]error // route -> delete error
+	AutoRoute     bool             // auto-route rules and default route removed
+	SplitRoute    bool             // uid and cgroup rules, marks and default route removed
+	KillSwitch    bool             // kill switch rules removed
+	TunClosed     bool
+	TunErr        error
+	Duration      time.Duration
+}
+
+func (r *ShutdownReport) String() string {
+	return fmt.Sprintf("tcp drained %d closed %d, udp drained %d closed %d, dns restored %v, routes removed %d failed %d, tun closed %v, took %v",
+		r.DrainedTCP, r.ClosedTCP, r.DrainedUDP, r.ClosedUDP, r.DNSRestored,
+		len(r.RoutesRemoved), len(r.RouteErrors), r.TunClosed, r.Duration)
+}
+
+// ShutdownReport return the report of the last shutdown, nil if tun2socks has never stopped
+func (app *App) ShutdownReport() *ShutdownReport {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.report
+}
+
+// shutdown run after the running context is done and the listeners are told to quit, in order:
+// wait listeners, drain live tunnels, stop the netstack, restore system dns, remove routes and the kill switch, close the tun.
+func (app *App) shutdown(listeners *util.WaitGroupWrapper, stopStack func()) *ShutdownReport {
+	start := time.Now()
+	report := &ShutdownReport{RouteErrors: make(map[string]error)}
+
+	listeners.WaitGroup.Wait()
+
+	app.drain(report)
+	stopStack()
+
+	app.mu.Lock()
+	sysDNS := app.sysDNS
+	app.sysDNS = false
+	app.mu.Unlock()
+	if sysDNS {
+		app.SetAndResetSystemDNSServers(false)
+		report.DNSRestored = true
+	}
+
+	app.removeRoutes(report)
+	if removed, err := app.removeAutoRoute(); err != nil {
+		report.RouteErrors["auto-route"] = err
+	} else {
+		report.AutoRoute = removed
+	}
+	if removed, err := app.removeSplitRoute(); err != nil {
+		report.RouteErrors["split-route"] = err
+	} else {
+		report.SplitRoute = removed
+	}
+	if removed, err := app.removeKillSwitch(); err != nil {
+		report.RouteErrors["kill-switch"] = err
+	} else {
+		report.KillSwitch = removed
+	}
+
+	report.TunErr = app.Dev.Close()
+	report.TunClosed = report.TunErr == nil
+	report.Duration = time.Since(start)
+
+	app.mu.Lock()
+	app.report = report
+	app.mu.Unlock()
+	return report
+}
+
+// drain wait live tunnels to finish in general.grace-period, then close the rest
+func (app *App) drain(report *ShutdownReport) {
+	tcpLive, udpLive := syncMapLen(&app.tcpTunnels), syncMapLen(&app.udpTunnels)
+	if tcpLive+udpLive > 0 {
+		log.Printf("[app] wait %d tcp and %d udp tunnels to finish in %ds", tcpLive, udpLive, app.Cfg.General.GracePeriod)
+		deadline := time.Now().Add(time.Duration(app.Cfg.General.GracePeriod) * time.Second)
+		for time.Now().Before(deadline) && syncMapLen(&app.tcpTunnels)+syncMapLen(&app.udpTunnels) > 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	app.tcpTunnels.Range(func(k, _ interface{}) bool {
+		k.(*TCPTunnel).Close(errShutdown)
+		report.ClosedTCP++
+		return true
+	})
+	app.udpTunnels.Range(func(_, v interface{}) bool {
+		v.(*UDPTunnel).Close(errShutdown)
+		report.ClosedUDP++
+		return true
+	})
+	report.DrainedTCP = tcpLive - report.ClosedTCP
+	report.DrainedUDP = udpLive - report.ClosedUDP
+}
+
+// removeRoutes delete the routes installed by AddRoutes
+func (app *App) removeRoutes(report *ShutdownReport) {
+	app.mu.Lock()
+	routes := app.Routes
+	app.mu.Unlock()
+	if routes == nil {
+		return
+	}
+
+	for _, r := range routes.Clear() {
+		if r.Err != nil {
+			report.RouteErrors[r.Route] = r.Err
+			continue
+		}
+		report.RoutesRemoved = append(report.RoutesRemoved, r.Route)
+	}
+}
+
+func syncMapLen(m *sync.Map) int {
+	n := 0
+	m.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
@@ -1,6 +1,7 @@
 package tun2socks
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
@@ -8,23 +9,36 @@ import (
 )
 
 // SignalHandler of darwin
-func (app *App) SignalHandler() *App {
+func (app *App) SignalHandler(ctx context.Context) *App {
 	// signal handler
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 
 	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
 	go func(app *App) {
-		for s := range c {
+		defer signal.Stop(c)
+		for {
+			var s os.Signal
+			select {
+			case <-ctx.Done():
+				return
+			case s = <-c:
+			}
 			switch s {
 			case syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
 				log.Println("[signal]", s)
 				app.Stop()
+				// stop listening, so a second signal quits without waiting the shutdown
+				return
 			case syscall.SIGUSR1:
 				log.Println("[signal]", s)
+				if err := app.DumpState(); err != nil {
+					log.Println("[signal] dump state failed", err)
+				}
 			case syscall.SIGUSR2:
 				log.Println("[signal]", s)
-				app.ReloadConfig()
-				break
+				if err := app.ReloadConfig(); err != nil {
+					log.Println("[signal] reload config failed, keep the running config:", err)
+				}
 			default:
 				log.Println("[signal]", s)
 			}
@@ -1,23 +1,33 @@
 package tun2socks
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
-func (app *App) SignalHandler() *App {
+func (app *App) SignalHandler(ctx context.Context) *App {
 	// signal handler
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 
 	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	go func(app *App) {
-		for s := range c {
+		defer signal.Stop(c)
+		for {
+			var s os.Signal
+			select {
+			case <-ctx.Done():
+				return
+			case s = <-c:
+			}
 			switch s {
 			case syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT:
 				log.Println("[signal]", s)
 				app.Stop()
+				// stop listening, so a second signal quits without waiting the shutdown
+				return
 			default:
 				log.Println("[signal]", s)
 			}
@@ -0,0 +1,240 @@
+package tun2socks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	socks5Version      = 0x05
+	socks5CmdConnect   = 0x01
+	socks5CmdAssociate = 0x03
+	socks5AtypIPv4     = 0x01
+	socks5AtypDomain   = 0x03
+	socks5AtypIPv6     = 0x04
+)
+
+// socks5Server is a socks5 server for tests, it supports CONNECT and UDP ASSOCIATE without auth.
+// Every CONNECT is forwarded to tcpTarget and every udp datagram to udpTarget,
+// the requested destinations are recorded.
+type socks5Server struct {
+	ln        net.Listener
+	tcpTarget string
+	udpTarget string
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func newSocks5Server(t *testing.T, tcpTarget, udpTarget string) *socks5Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &socks5Server{ln: ln, tcpTarget: tcpTarget, udpTarget: udpTarget}
+	go s.serve()
+	return s
+}
+
+func (s *socks5Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *socks5Server) Close() error {
+	return s.ln.Close()
+}
+
+// Targets return the requested destinations, host:port
+func (s *socks5Server) Targets() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.targets...)
+}
+
+func (s *socks5Server) record(host string, port uint16) {
+	s.mu.Lock()
+	s.targets = append(s.targets, net.JoinHostPort(host, strconv.Itoa(int(port))))
+	s.mu.Unlock()
+}
+
+func (s *socks5Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *socks5Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	// VER NMETHODS METHODS
+	buf := make([]byte, 255)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil || buf[0] != socks5Version {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{socks5Version, 0x00}); err != nil {
+		return
+	}
+
+	// VER CMD RSV DST.ADDR DST.PORT
+	if _, err := io.ReadFull(conn, buf[:3]); err != nil {
+		return
+	}
+	cmd := buf[1]
+	host, port, err := readSocks5Addr(conn)
+	if err != nil {
+		return
+	}
+
+	switch cmd {
+	case socks5CmdConnect:
+		s.record(host, port)
+		s.connect(conn)
+	case socks5CmdAssociate:
+		s.associate(conn)
+	default:
+		conn.Write(socks5Reply(0x07, &net.TCPAddr{IP: net.IPv4zero}))
+	}
+}
+
+func (s *socks5Server) connect(conn net.Conn) {
+	remote, err := net.DialTimeout("tcp", s.tcpTarget, time.Second)
+	if err != nil {
+		conn.Write(socks5Reply(0x05, &net.TCPAddr{IP: net.IPv4zero}))
+		return
+	}
+	defer remote.Close()
+	if _, err := conn.Write(socks5Reply(0x00, remote.LocalAddr().(*net.TCPAddr))); err != nil {
+		return
+	}
+
+	go func() {
+		io.Copy(remote, conn)
+		remote.Close()
+	}()
+	io.Copy(conn, remote)
+}
+
+func (s *socks5Server) associate(conn net.Conn) {
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		conn.Write(socks5Reply(0x01, &net.TCPAddr{IP: net.IPv4zero}))
+		return
+	}
+	defer relay.Close()
+	bnd := relay.LocalAddr().(*net.UDPAddr)
+	if _, err := conn.Write(socks5Reply(0x00, &net.TCPAddr{IP: bnd.IP, Port: bnd.Port})); err != nil {
+		return
+	}
+
+	go s.relay(relay)
+	// the association lives as long as the tcp connection
+	io.Copy(ioutil.Discard, conn)
+}
+
+func (s *socks5Server) relay(relay *net.UDPConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, client, err := relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		// RSV FRAG DST.ADDR DST.PORT DATA
+		r := bytes.NewReader(buf[3:n])
+		host, port, err := readSocks5Addr(r)
+		if err != nil {
+			continue
+		}
+		data, _ := ioutil.ReadAll(r)
+		s.record(host, port)
+
+		go func(host string, port uint16, data []byte, client *net.UDPAddr) {
+			upstream, err := net.Dial("udp", s.udpTarget)
+			if err != nil {
+				return
+			}
+			defer upstream.Close()
+			if _, err := upstream.Write(data); err != nil {
+				return
+			}
+			upstream.SetReadDeadline(time.Now().Add(2 * time.Second))
+			rbuf := make([]byte, 65535)
+			m, err := upstream.Read(rbuf)
+			if err != nil {
+				return
+			}
+			reply := appendSocks5Addr([]byte{0, 0, 0}, host, port)
+			relay.WriteToUDP(append(reply, rbuf[:m]...), client)
+		}(host, port, data, client)
+	}
+}
+
+func readSocks5Addr(r io.Reader) (string, uint16, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", 0, err
+	}
+
+	var host string
+	switch atyp[0] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp[0] == socks5AtypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", 0, err
+		}
+		host = ip.String()
+	case socks5AtypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(r, l); err != nil {
+			return "", 0, err
+		}
+		domain := make([]byte, l[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", 0, err
+		}
+		host = string(domain)
+	default:
+		return "", 0, errors.New("unknown address type")
+	}
+
+	var port uint16
+	if err := binary.Read(r, binary.BigEndian, &port); err != nil {
+		return "", 0, err
+	}
+	return host, port, nil
+}
+
+func appendSocks5Addr(b []byte, host string, port uint16) []byte {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(append(b, socks5AtypIPv4), ip4...)
+		} else {
+			b = append(append(b, socks5AtypIPv6), ip.To16()...)
+		}
+	} else {
+		b = append(append(b, socks5AtypDomain, byte(len(host))), host...)
+	}
+	return append(b, byte(port>>8), byte(port))
+}
+
+func socks5Reply(rep byte, bnd *net.TCPAddr) []byte {
+	return appendSocks5Addr([]byte{socks5Version, rep, 0x00}, bnd.IP.String(), uint16(bnd.Port))
+}
@@ -0,0 +1,95 @@
+package tun2socks
+
+import (
+	"log"
+
+	"github.com/FlowerWrong/tun2socks/util"
+)
+
+// the netlink and nftables calls of split route, replaced by tests
+var (
+	installSplitRoute   = (*util.SplitRoute).Install
+	uninstallSplitRoute = (*util.SplitRoute).Remove
+)
+
+// splitRouteConfig build the split route of route.uid and route.cgroup, nil if neither is set
+func (app *App) splitRouteConfig() (*util.SplitRoute, error) {
+	route := app.Cfg.Route
+	if len(route.UID) == 0 && len(route.Cgroup) == 0 {
+		return nil, nil
+	}
+	sr := &util.SplitRoute{
+		Tun:      app.Dev.Name(),
+		Table:    route.Table,
+		Mark:     route.SplitMark,
+		FWMark:   route.FWMark,
+		Priority: route.RulePriority,
+		Cgroups:  route.Cgroup,
+		Exclude:  app.proxyNets(),
+	}
+	for _, s := range route.UID {
+		uids, err := util.ParseUIDRange(s)
+		if err != nil {
+			return nil, &ConfigError{File: app.Cfg.File, Err: err}
+		}
+		sr.UIDs = append(sr.UIDs, uids)
+	}
+	return sr, nil
+}
+
+// SetupSplitRoute route only the sockets of route.uid and route.cgroup into the tun, see util.SplitRoute
+func (app *App) SetupSplitRoute() error {
+	sr, err := app.splitRouteConfig()
+	if err != nil || sr == nil {
+		return err
+	}
+	if err := installSplitRoute(sr); err != nil {
+		return &TunError{Op: "split route", Err: err}
+	}
+	log.Printf("[route] split route to %s by table %d for uids %v and cgroups %v", sr.Tun, sr.Table, sr.UIDs, sr.Cgroups)
+
+	app.mu.Lock()
+	app.splitRoute = sr
+	app.mu.Unlock()
+	return nil
+}
+
+// reloadSplitRoute replace the split route by the uids and cgroups of the reloaded config, none of them removes it.
+// The previous one is removed first by its own table and priorities, which the reload may have changed.
+func (app *App) reloadSplitRoute() {
+	if app.Cfg.Route.AutoRoute {
+		return
+	}
+	sr, err := app.splitRouteConfig()
+	if err != nil {
+		log.Println("[route] reload split route failed", err)
+		return
+	}
+	app.removeSplitRoute()
+	if sr == nil {
+		return
+	}
+	if err := installSplitRoute(sr); err != nil {
+		log.Println("[route] reload split route failed", err)
+		return
+	}
+	app.mu.Lock()
+	app.splitRoute = sr
+	app.mu.Unlock()
+}
+
+// removeSplitRoute delete the rules, the default route and the cgroup marks of split route
+func (app *App) removeSplitRoute() (bool, error) {
+	app.mu.Lock()
+	sr := app.splitRoute
+	app.splitRoute = nil
+	app.mu.Unlock()
+	if sr == nil {
+		return false, nil
+	}
+	if err := uninstallSplitRoute(sr); err != nil {
+		log.Println("[route] remove split route failed", err)
+		return false, err
+	}
+	return true, nil
+}
@@ -1,11 +1,16 @@
 package tun2socks
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"strings"
 
 	"github.com/FlowerWrong/netstack/tcpip"
+	"github.com/FlowerWrong/netstack/tcpip/buffer"
+	"github.com/FlowerWrong/netstack/tcpip/link/channel"
 	"github.com/FlowerWrong/netstack/tcpip/link/fdbased"
 	"github.com/FlowerWrong/netstack/tcpip/network/ipv4"
 	"github.com/FlowerWrong/netstack/tcpip/network/ipv6"
@@ -23,7 +28,7 @@ const (
 )
 
 // NewNetstack create a tcp/ip stack
-func NewNetstack(app *App) tcpip.NetworkProtocolNumber {
+func NewNetstack(ctx context.Context, app *App) (tcpip.NetworkProtocolNumber, error) {
 	tunIP, _, _ := net.ParseCIDR(app.Cfg.General.Network)
 
 	// Parse the IP address. Support both ipv4 and ipv6.
@@ -36,7 +41,7 @@ func NewNetstack(app *App) tcpip.NetworkProtocolNumber {
 		addr = tcpip.Address(tunIP.To16())
 		proto = ipv6.ProtocolNumber
 	} else {
-		log.Fatalf("Unknown IP type: %v", app.Cfg.General.Network)
+		return 0, &NetstackError{Op: "parse address", Err: fmt.Errorf("unknown IP type: %v", app.Cfg.General.Network)}
 	}
 
 	// Create the stack with ip and tcp protocols, then add a tun-based NIC and address.
@@ -44,31 +49,38 @@ func NewNetstack(app *App) tcpip.NetworkProtocolNumber {
 
 	app.HookPort = util.NewRandomPort(app.S)
 	if app.HookPort == 0 {
-		log.Fatal("New random port failed")
+		return 0, &NetstackError{Op: "pick port", Err: errors.New("new random port failed")}
 	}
 
 	// Parse the mac address.
 	maddr, err := net.ParseMAC("aa:00:01:01:01:01")
 	if err != nil {
-		log.Fatalf("Bad MAC address: aa:00:01:01:01:01")
+		return 0, &NetstackError{Op: "parse mac", Err: err}
 	}
 
-	linkID, err := fdbased.New(&fdbased.Options{
-		FD:                 app.Ifce.Fd(),
-		MTU:                app.Cfg.General.Mtu,
-		EthernetHeader:     false,
-		Address:            tcpip.LinkAddress(maddr),
-		PacketDispatchMode: fdbased.Readv,
-	})
+	linkID, err := newLinkEndpoint(ctx, app.Dev, tcpip.LinkAddress(maddr))
 	if err != nil {
-		log.Fatal(err)
+		return 0, &NetstackError{Op: "new link endpoint", Err: err}
 	}
+	linkID, natEP := newNatEndpoint(linkID, &app.udpNat, app.Cfg.TCP.MSS)
 	if err := app.S.CreateNIC(NICId, linkID, true, addr, app.HookPort); err != nil {
-		log.Fatal("Create NIC failed", err)
+		return 0, &NetstackError{Op: "create nic", Err: errors.New(err.String())}
+	}
+
+	// the extra queues of a multi-queue tun are read by their own fdbased dispatchers into the same nic,
+	// packets are written to the first queue only.
+	if mq, ok := app.Dev.(multiQueueDevice); ok {
+		for _, fd := range mq.QueueFds() {
+			queueID, err := newFdEndpoint(fd, app.Dev.MTU(), tcpip.LinkAddress(maddr))
+			if err != nil {
+				return 0, &NetstackError{Op: "new queue endpoint", Err: err}
+			}
+			stack.FindLinkEndpoint(queueID).Attach(natEP)
+		}
 	}
 
 	if err := app.S.AddAddress(NICId, proto, addr); err != nil {
-		log.Fatal("Add address to stack failed", err)
+		return 0, &NetstackError{Op: "add address", Err: errors.New(err.String())}
 	}
 
 	// Add default route.
@@ -80,5 +92,71 @@ func NewNetstack(app *App) tcpip.NetworkProtocolNumber {
 			NIC:         NICId,
 		},
 	})
-	return proto
+	return proto, nil
+}
+
+// newLinkEndpoint create a link endpoint for dev. A fdbased endpoint is used if dev has a fd,
+// otherwise packets are pumped between dev and a channel endpoint until ctx is done.
+func newLinkEndpoint(ctx context.Context, dev Device, linkAddr tcpip.LinkAddress) (tcpip.LinkEndpointID, error) {
+	if fd := dev.Fd(); fd >= 0 {
+		return newFdEndpoint(fd, dev.MTU(), linkAddr)
+	}
+
+	linkID, ep := channel.New(PktChannelSize, dev.MTU(), linkAddr)
+	go readFromDeviceWriteToStack(dev, ep)
+	go readFromStackWriteToDevice(ctx, dev, ep)
+	return linkID, nil
+}
+
+func newFdEndpoint(fd int, mtu uint32, linkAddr tcpip.LinkAddress) (tcpip.LinkEndpointID, error) {
+	return fdbased.New(&fdbased.Options{
+		FD:                 fd,
+		MTU:                mtu,
+		EthernetHeader:     false,
+		Address:            linkAddr,
+		PacketDispatchMode: fdbased.Readv,
+	})
+}
+
+func readFromDeviceWriteToStack(dev Device, ep *channel.Endpoint) {
+	for {
+		pkt := make([]byte, dev.MTU())
+		n, err := dev.Read(pkt)
+		if err != nil {
+			if !util.IsEOF(err) {
+				log.Println("[device] read from", dev.Name(), "failed", err)
+			}
+			return
+		}
+		if n == 0 {
+			continue
+		}
+
+		var proto tcpip.NetworkProtocolNumber
+		if util.IsIPv4(pkt) {
+			proto = ipv4.ProtocolNumber
+		} else if util.IsIPv6(pkt) {
+			proto = ipv6.ProtocolNumber
+		} else {
+			continue
+		}
+		ep.Inject(proto, buffer.View(pkt[:n]).ToVectorisedView())
+	}
+}
+
+func readFromStackWriteToDevice(ctx context.Context, dev Device, ep *channel.Endpoint) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case info := <-ep.C:
+			pkt := make([]byte, 0, len(info.Header)+len(info.Payload))
+			pkt = append(pkt, info.Header...)
+			pkt = append(pkt, info.Payload...)
+			if _, err := dev.Write(pkt); err != nil {
+				log.Println("[device] write to", dev.Name(), "failed", err)
+				return
+			}
+		}
+	}
 }
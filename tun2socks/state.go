@@ -0,0 +1,206 @@
+package tun2socks
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/FlowerWrong/gosocks"
+	"github.com/FlowerWrong/netstack/tcpip"
+	"github.com/FlowerWrong/tun2socks/dns"
+)
+
+// TunnelState is a snapshot of a tcp or udp tunnel
+type TunnelState struct {
+	Src      string
+	Dst      string
+	Hostname string // of the fake ip, empty if it is not
+	Proxy    string
+	Sent     int64 // bytes from local to remote
+	Received int64
+	Age      time.Duration
+}
+
+// ProxyState is a proxy in [proxy], the user info of the url is removed
+type ProxyState struct {
+	Name    string
+	URL     string
+	Default bool
+}
+
+// State is a snapshot of the running tun2socks, SIGUSR1 dumps it, see DumpState
+type State struct {
+	Time     time.Time
+	TCP      []TunnelState // oldest first
+	UDP      []TunnelState
+	DNS      *dns.TableStats // nil if dns-mode is not fake
+	Proxies  []ProxyState
+	Netstack tcpip.Stats
+}
+
+// State take a snapshot of the tunnels, the fake dns table, the proxies and the netstack statistics
+func (app *App) State() *State {
+	now := time.Now()
+	state := &State{Time: now}
+
+	app.tcpTunnels.Range(func(k, _ interface{}) bool {
+		t := k.(*TCPTunnel)
+		src := ""
+		if addr, err := t.localEndpoint.GetRemoteAddress(); err == nil {
+			src = fmt.Sprintf("%v:%d", net.IP(addr.Addr), addr.Port)
+		}
+		state.TCP = append(state.TCP, TunnelState{
+			Src:      src,
+			Dst:      t.dst,
+			Hostname: t.hostname,
+			Proxy:    t.proxy,
+			Sent:     atomic.LoadInt64(&t.sent),
+			Received: atomic.LoadInt64(&t.received),
+			Age:      now.Sub(t.created),
+		})
+		return true
+	})
+	app.udpTunnels.Range(func(_, v interface{}) bool {
+		t := v.(*UDPTunnel)
+		hostname := ""
+		if t.remoteHostType == gosocks.SocksDomainHost {
+			hostname = t.remoteHost
+		}
+		state.UDP = append(state.UDP, TunnelState{
+			Src:      fmt.Sprintf("%v:%d", net.IP(t.localAddr.Addr), t.localAddr.Port),
+			Dst:      fmt.Sprintf("%v:%d", net.IP(t.localEndpoint.LocalAddress), t.remotePort),
+			Hostname: hostname,
+			Proxy:    t.proxy,
+			Sent:     atomic.LoadInt64(&t.localBufLen),
+			Received: atomic.LoadInt64(&t.remoteBufLen),
+			Age:      now.Sub(t.created),
+		})
+		return true
+	})
+	sortTunnels(state.TCP)
+	sortTunnels(state.UDP)
+
+	if app.FakeDNS != nil {
+		stats := app.FakeDNS.DNSTablePtr.Stats()
+		state.DNS = &stats
+	}
+
+	var defaultProxy string
+	if app.Proxies != nil {
+		defaultProxy = app.Proxies.Default
+	}
+	for name, p := range app.Cfg.Proxy {
+		u := p.URL
+		if parsed, err := url.Parse(p.URL); err == nil {
+			parsed.User = nil
+			u = parsed.String()
+		}
+		state.Proxies = append(state.Proxies, ProxyState{Name: name, URL: u, Default: name == defaultProxy})
+	}
+	sort.Slice(state.Proxies, func(i, j int) bool { return state.Proxies[i].Name < state.Proxies[j].Name })
+	for i := range state.TCP {
+		if state.TCP[i].Proxy == "" {
+			state.TCP[i].Proxy = defaultProxy
+		}
+	}
+
+	if app.S != nil {
+		state.Netstack = app.S.Stats()
+	}
+	return state
+}
+
+func sortTunnels(tunnels []TunnelState) {
+	sort.Slice(tunnels, func(i, j int) bool { return tunnels[i].Age > tunnels[j].Age })
+}
+
+// WriteTo write the state as text
+func (s *State) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "tun2socks state at %s\n", s.Time.Format(time.RFC3339))
+
+	writeTunnels := func(name string, tunnels []TunnelState) {
+		fmt.Fprintf(&b, "%s tunnels: %d\n", name, len(tunnels))
+		for _, t := range tunnels {
+			dst := t.Dst
+			if t.Hostname != "" {
+				dst = fmt.Sprintf("%s (%s)", t.Dst, t.Hostname)
+			}
+			fmt.Fprintf(&b, "  %s -> %s proxy %q sent %d received %d age %v\n",
+				t.Src, dst, t.Proxy, t.Sent, t.Received, t.Age.Truncate(time.Second))
+		}
+	}
+	writeTunnels("tcp", s.TCP)
+	writeTunnels("udp", s.UDP)
+
+	if s.DNS != nil {
+		fmt.Fprintf(&b, "fake dns: %d records, %d non proxy domains, ip pool %d/%d used\n",
+			s.DNS.Records, s.DNS.NonProxyDomains, s.DNS.PoolUsed, s.DNS.PoolCapacity)
+	}
+
+	fmt.Fprintf(&b, "proxies: %d\n", len(s.Proxies))
+	for _, p := range s.Proxies {
+		def := ""
+		if p.Default {
+			def = " (default)"
+		}
+		fmt.Fprintf(&b, "  %s %s%s\n", p.Name, p.URL, def)
+	}
+
+	n := s.Netstack
+	fmt.Fprintf(&b, "netstack: unknown protocol %d, malformed %d, dropped %d\n",
+		value(n.UnknownProtocolRcvdPackets), value(n.MalformedRcvdPackets), value(n.DroppedPackets))
+	fmt.Fprintf(&b, "  ip: received %d, invalid address %d, delivered %d, sent %d, send errors %d\n",
+		value(n.IP.PacketsReceived), value(n.IP.InvalidAddressesReceived), value(n.IP.PacketsDelivered),
+		value(n.IP.PacketsSent), value(n.IP.OutgoingPacketErrors))
+	fmt.Fprintf(&b, "  tcp: active opens %d, passive opens %d, failed %d, segments received %d invalid %d sent %d, resets sent %d received %d\n",
+		value(n.TCP.ActiveConnectionOpenings), value(n.TCP.PassiveConnectionOpenings), value(n.TCP.FailedConnectionAttempts),
+		value(n.TCP.ValidSegmentsReceived), value(n.TCP.InvalidSegmentsReceived), value(n.TCP.SegmentsSent),
+		value(n.TCP.ResetsSent), value(n.TCP.ResetsReceived))
+	fmt.Fprintf(&b, "  udp: received %d, unknown port %d, receive buffer errors %d, malformed %d, sent %d\n",
+		value(n.UDP.PacketsReceived), value(n.UDP.UnknownPortErrors), value(n.UDP.ReceiveBufferErrors),
+		value(n.UDP.MalformedPacketsReceived), value(n.UDP.PacketsSent))
+
+	m, err := io.WriteString(w, b.String())
+	return int64(m), err
+}
+
+// value of a stat counter, 0 if the netstack has not created it
+func value(c *tcpip.StatCounter) uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.Value()
+}
+
+// DumpState write the state to general.state-file, or the log if it is empty
+func (app *App) DumpState() error {
+	state := app.State()
+	if app.Cfg.General.StateFile == "" {
+		var b strings.Builder
+		state.WriteTo(&b)
+		log.Print("[state] ", b.String())
+		return nil
+	}
+
+	f, err := os.OpenFile(app.Cfg.General.StateFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := state.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Println("[state] dumped to", app.Cfg.General.StateFile)
+	return nil
+}
@@ -1,6 +1,7 @@
 package tun2socks
 
 import (
+	"context"
 	"errors"
 	"log"
 	"net"
@@ -11,20 +12,35 @@ import (
 )
 
 // NewTCPEndpointAndListenIt create a TCP endpoint, bind it, then start listening.
-func (app *App) NewTCPEndpointAndListenIt() error {
-	var wq waiter.Queue
-	ep, err := app.S.NewEndpoint(tcp.ProtocolNumber, app.NetworkProtocolNumber, &wq)
+func (app *App) NewTCPEndpointAndListenIt(ctx context.Context) error {
+	ep, wq, err := app.listenTCP()
 	if err != nil {
-		return errors.New(err.String())
+		return err
 	}
+	return app.acceptTCP(ctx, ep, wq)
+}
 
-	defer ep.Close()
+// listenTCP create the listening TCP endpoint of the netstack
+func (app *App) listenTCP() (tcpip.Endpoint, *waiter.Queue, error) {
+	wq := new(waiter.Queue)
+	ep, err := app.S.NewEndpoint(tcp.ProtocolNumber, app.NetworkProtocolNumber, wq)
+	if err != nil {
+		return nil, nil, errors.New(err.String())
+	}
 	if err := ep.Bind(tcpip.FullAddress{NICId, "", app.HookPort}); err != nil {
-		return errors.New(err.String())
+		ep.Close()
+		return nil, nil, errors.New(err.String())
 	}
 	if err := ep.Listen(Backlog); err != nil {
-		return errors.New(err.String())
+		ep.Close()
+		return nil, nil, errors.New(err.String())
 	}
+	return ep, wq, nil
+}
+
+// acceptTCP tunnel the connections of ep until ctx is done, ep is closed when it returns
+func (app *App) acceptTCP(ctx context.Context, ep tcpip.Endpoint, wq *waiter.Queue) error {
+	defer ep.Close()
 
 	// Wait for connections to appear.
 	waitEntry, notifyCh := waiter.NewChannelEntry(nil)
@@ -33,7 +49,7 @@ func (app *App) NewTCPEndpointAndListenIt() error {
 
 	for {
 		select {
-		case <-QuitTCPNetstack:
+		case <-ctx.Done():
 			log.Println("quit tcp netstack")
 			return nil
 		default:
@@ -43,7 +59,7 @@ func (app *App) NewTCPEndpointAndListenIt() error {
 		if err != nil {
 			if err == tcpip.ErrWouldBlock {
 				select {
-				case <-QuitTCPNetstack:
+				case <-ctx.Done():
 					log.Println("quit tcp netstack")
 					return nil
 				case <-notifyCh:
@@ -57,7 +73,7 @@ func (app *App) NewTCPEndpointAndListenIt() error {
 		// TODO ipv6
 		ip := net.ParseIP(local.Addr.To4().String())
 
-		contains, _ := IgnoreRanger.Contains(ip)
+		contains, _ := app.IgnoreRanger.Contains(ip)
 		if contains {
 			endpoint.Close()
 			continue
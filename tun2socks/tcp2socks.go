@@ -7,6 +7,7 @@ import (
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/FlowerWrong/netstack/tcpip"
@@ -16,6 +17,8 @@ import (
 
 // TCPTunnel struct
 type TCPTunnel struct {
+	sent                 int64 // bytes from local to remote, first for the 64-bit alignment of atomic
+	received             int64 // bytes from remote to local
 	wq                   *waiter.Queue
 	localEndpoint        tcpip.Endpoint
 	localEndpointStatus  TunnelStatus // to avoid panic: send on closed channel
@@ -28,11 +31,15 @@ type TCPTunnel struct {
 	ctxCancel            context.CancelFunc
 	closeOne             sync.Once // to avoid multi close tunnel
 	app                  *App
+	dst                  string // ip:port the local connected to
+	hostname             string // of the fake ip, empty if it is not
+	proxy                string
+	created              time.Time
 }
 
 // NewTCP2Socks create a tcp tunnel
 func NewTCP2Socks(wq *waiter.Queue, ep tcpip.Endpoint, ip net.IP, port uint16, app *App) (*TCPTunnel, error) {
-	var remoteAddr string
+	var remoteAddr, hostname string
 	proxy := ""
 
 	if app.FakeDNS != nil {
@@ -43,6 +50,7 @@ func NewTCP2Socks(wq *waiter.Queue, ep tcpip.Endpoint, ip net.IP, port uint16, a
 			}
 
 			remoteAddr = fmt.Sprintf("%v:%d", record.Hostname, port)
+			hostname = record.Hostname
 			proxy = record.Proxy
 		} else {
 			remoteAddr = fmt.Sprintf("%v:%d", ip, port)
@@ -51,15 +59,17 @@ func NewTCP2Socks(wq *waiter.Queue, ep tcpip.Endpoint, ip net.IP, port uint16, a
 		remoteAddr = fmt.Sprintf("%v:%d", ip, port)
 	}
 
-	socks5Conn, err := app.Proxies.Dial(proxy, remoteAddr)
+	socks5Conn, err := app.dialProxy(proxy, remoteAddr)
 	if err != nil {
 		log.Printf("[tcp] dial %s by proxy %q failed: %s", remoteAddr, proxy, err)
 		return nil, err
 	}
-	socks5Conn.(*net.TCPConn).SetKeepAlive(true)
+	if tcpConn, ok := socks5Conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+	}
 	socks5Conn.SetDeadline(WithoutTimeout)
 
-	return &TCPTunnel{
+	tcpTunnel := &TCPTunnel{
 		wq:                   wq,
 		localEndpoint:        ep,
 		remoteConn:           socks5Conn,
@@ -67,7 +77,24 @@ func NewTCP2Socks(wq *waiter.Queue, ep tcpip.Endpoint, ip net.IP, port uint16, a
 		localEndpointRwMutex: sync.RWMutex{},
 		remoteRwMutex:        sync.RWMutex{},
 		app:                  app,
-	}, nil
+		dst:                  fmt.Sprintf("%v:%d", ip, port),
+		hostname:             hostname,
+		proxy:                proxy,
+		created:              time.Now(),
+	}
+	tcpTunnel.ctx, tcpTunnel.ctxCancel = context.WithCancel(context.Background())
+	app.tcpTunnels.Store(tcpTunnel, struct{}{})
+	return tcpTunnel, nil
+}
+
+// dialProxy connect addr by the proxy name, the default proxy if it is empty.
+// The socket to the proxy server is marked under auto-route, split route or the kill switch.
+func (app *App) dialProxy(name, addr string) (net.Conn, error) {
+	mark := app.socketMark()
+	if mark == 0 {
+		return app.Proxies.Dial(name, addr)
+	}
+	return app.Proxies.DialWith(util.Dialer(DefaultConnectDuration, mark), name, addr)
 }
 
 // SetRemoteStatus with rwMutex
@@ -102,7 +129,6 @@ func (tcpTunnel *TCPTunnel) LocalEndpointStatus() TunnelStatus {
 
 // Run start tcp tunnel
 func (tcpTunnel *TCPTunnel) Run() {
-	tcpTunnel.ctx, tcpTunnel.ctxCancel = context.WithCancel(context.Background())
 	wgw := new(util.WaitGroupWrapper)
 	wgw.Wrap(func() {
 		tcpTunnel.readFromRemoteWriteToLocal()
@@ -157,6 +183,7 @@ readFromLocal:
 						break writeAllPacket
 					}
 					n, err := tcpTunnel.remoteConn.Write(v)
+					atomic.AddInt64(&tcpTunnel.sent, int64(n))
 					if err != nil {
 						if util.IsBrokenPipe(err) || util.IsEOF(err) {
 							tcpTunnel.Close(nil)
@@ -211,6 +238,7 @@ readFromRemote:
 					var err *tcpip.Error
 					m, _, err = tcpTunnel.localEndpoint.Write(tcpip.SlicePayload(chunk), tcpip.WriteOptions{})
 					n := int(m)
+					atomic.AddInt64(&tcpTunnel.received, int64(n))
 					if err != nil {
 						if err == tcpip.ErrWouldBlock {
 							if n < len(chunk) {
@@ -252,6 +280,7 @@ func (tcpTunnel *TCPTunnel) Close(reason error) {
 		tcpTunnel.SetLocalEndpointStatus(StatusClosed)
 		tcpTunnel.SetRemoteStatus(StatusClosed)
 
+		tcpTunnel.app.tcpTunnels.Delete(tcpTunnel)
 		tcpTunnel.ctxCancel()
 
 		tcpTunnel.localEndpoint.Close()
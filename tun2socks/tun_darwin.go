@@ -9,23 +9,31 @@ import (
 	"github.com/FlowerWrong/water"
 )
 
-func Ifconfig(tunName, network string, mtu uint32) {
+func Ifconfig(tunName, network string, mtu uint32) error {
 	var ip, ipv4Net, _ = net.ParseCIDR(network)
 	ipStr := ip.To4().String()
 	sargs := fmt.Sprintf("%s %s %s mtu %d netmask %s up", tunName, ipStr, ipStr, mtu, util.Ipv4MaskString(ipv4Net.Mask))
 	if err := util.ExecCommand("ifconfig", sargs); err != nil {
-		log.Fatal("execCommand failed", err)
+		return &TunError{Op: "ifconfig", Err: err}
 	}
+	return nil
 }
 
-func NewTun(app *App) {
-	var err error
-	app.Ifce, err = water.New(water.Config{
+func NewTun(app *App) error {
+	ifce, err := water.New(water.Config{
 		DeviceType: water.TUN,
+		PlatformSpecificParams: water.PlatformSpecificParams{
+			Name: app.Cfg.General.TunName,
+		},
 	})
 	if err != nil {
-		log.Fatal("Create tun interface failed", err)
+		return &TunError{Op: "create", Err: err}
 	}
-	log.Println("[tun] interface name is", app.Ifce.Name())
-	Ifconfig(app.Ifce.Name(), app.Cfg.General.Network, app.Cfg.General.Mtu)
+	log.Println("[tun] interface name is", ifce.Name())
+	if err := Ifconfig(ifce.Name(), app.Cfg.General.Network, app.Cfg.General.Mtu); err != nil {
+		ifce.Close()
+		return err
+	}
+	app.Dev = NewTunDevice(ifce, app.Cfg.General.Mtu)
+	return nil
 }
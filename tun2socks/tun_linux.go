@@ -4,28 +4,131 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"os/user"
+	"strconv"
 
+	"github.com/FlowerWrong/tun2socks/configure"
 	"github.com/FlowerWrong/tun2socks/util"
 	"github.com/FlowerWrong/water"
 )
 
-func Ifconfig(tunName, network string, _ uint32) {
-	var ip, ipv4Net, _ = net.ParseCIDR(network)
-	ipStr := ip.To4().String()
-	sargs := fmt.Sprintf("%s %s netmask %s", tunName, ipStr, util.Ipv4MaskString(ipv4Net.Mask))
-	if err := util.ExecCommand("ifconfig", sargs); err != nil {
-		log.Fatal("execCommand failed", err)
+// Ifconfig set the address and mtu of the tun by netlink, then bring it up
+func Ifconfig(tunName, network string, mtu uint32) error {
+	ip, ipv4Net, err := net.ParseCIDR(network)
+	if err != nil {
+		return &TunError{Op: "ifconfig", Err: err}
+	}
+	addr := &net.IPNet{IP: ip.To4(), Mask: ipv4Net.Mask}
+	if err := util.SetupLink(tunName, addr, int(mtu)); err != nil {
+		return &TunError{Op: "ifconfig", Err: err}
 	}
+	return nil
 }
 
-func NewTun(app *App) {
-	var err error
-	app.Ifce, err = water.New(water.Config{
-		DeviceType: water.TUN,
+func NewTun(app *App) error {
+	dev, err := createTun(app.Cfg.General)
+	if err != nil {
+		return err
+	}
+	if err := Ifconfig(dev.Name(), app.Cfg.General.Network, app.Cfg.General.Mtu); err != nil {
+		dev.Close()
+		return err
+	}
+	app.Dev = dev
+	return nil
+}
+
+// createTun create a tun with the extra queues of it, the interface is not configured
+func createTun(general configure.GeneralConfig) (Device, error) {
+	params, err := tunParams(general)
+	if err != nil {
+		return nil, &TunError{Op: "create", Err: err}
+	}
+	ifce, err := water.New(water.Config{
+		DeviceType:             water.TUN,
+		PlatformSpecificParams: params,
 	})
 	if err != nil {
-		log.Fatal("Create tun interface failed", err)
+		return nil, &TunError{Op: "create", Err: err}
+	}
+	log.Println("[tun] interface name is", ifce.Name())
+
+	// extra queues are attached to the tun by its name
+	var queues []*water.Interface
+	params.Name = ifce.Name()
+	for i := 1; i < general.TunQueues; i++ {
+		q, err := water.New(water.Config{
+			DeviceType:             water.TUN,
+			PlatformSpecificParams: params,
+		})
+		if err != nil {
+			NewTunDevice(ifce, general.Mtu, queues...).Close()
+			return nil, &TunError{Op: "create queue", Err: err}
+		}
+		queues = append(queues, q)
+	}
+	if len(queues) > 0 {
+		log.Printf("[tun] %s has %d queues", ifce.Name(), len(queues)+1)
+	}
+	return NewTunDevice(ifce, general.Mtu, queues...), nil
+}
+
+func tunParams(general configure.GeneralConfig) (water.PlatformSpecificParams, error) {
+	params := water.PlatformSpecificParams{
+		Name:       general.TunName,
+		Persist:    general.TunPersist,
+		MultiQueue: general.TunQueues > 1,
+	}
+	if general.TunOwner == "" && general.TunGroup == "" {
+		return params, nil
+	}
+
+	// the owner and group are set together, a missing group is the primary group of the owner, a missing owner is root
+	var uid, gid uint64
+	var err error
+	if general.TunOwner != "" {
+		var u *user.User
+		if u, err = lookupUser(general.TunOwner); err != nil {
+			return params, err
+		}
+		uid, _ = strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ = strconv.ParseUint(u.Gid, 10, 32)
+	}
+	if general.TunGroup != "" {
+		var g *user.Group
+		if g, err = lookupGroup(general.TunGroup); err != nil {
+			return params, err
+		}
+		gid, _ = strconv.ParseUint(g.Gid, 10, 32)
+	}
+	params.Permissions = &water.DevicePermissions{Owner: uint(uid), Group: uint(gid)}
+	return params, nil
+}
+
+// lookupUser by name or uid
+func lookupUser(s string) (*user.User, error) {
+	if _, err := strconv.ParseUint(s, 10, 32); err == nil {
+		if u, err := user.LookupId(s); err == nil {
+			return u, nil
+		}
+		// a uid without passwd entry
+		return &user.User{Uid: s, Gid: s}, nil
+	}
+	u, err := user.Lookup(s)
+	if err != nil {
+		return nil, fmt.Errorf("tun-owner: %v", err)
+	}
+	return u, nil
+}
+
+// lookupGroup by name or gid
+func lookupGroup(s string) (*user.Group, error) {
+	if _, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return &user.Group{Gid: s}, nil
+	}
+	g, err := user.LookupGroup(s)
+	if err != nil {
+		return nil, fmt.Errorf("tun-group: %v", err)
 	}
-	log.Println("[tun] interface name is", app.Ifce.Name())
-	Ifconfig(app.Ifce.Name(), app.Cfg.General.Network, app.Cfg.General.Mtu)
+	return g, nil
 }
@@ -9,18 +9,22 @@ import (
 	"github.com/FlowerWrong/water"
 )
 
-func Ifconfig(tunName, network string, _ uint32) {
+func Ifconfig(tunName, network string, mtu uint32) error {
 	var ip, ipv4Net, _ = net.ParseCIDR(network)
 	ipStr := ip.To4().String()
 	sargs := fmt.Sprintf("interface ip set address \"%s\" static %s %s none", tunName, ipStr, util.Ipv4MaskString(ipv4Net.Mask))
 	if err := util.ExecCommand("netsh", sargs); err != nil {
-		log.Fatal("execCommand failed", err)
+		return &TunError{Op: "ifconfig", Err: err}
 	}
+	sargs = fmt.Sprintf("interface ipv4 set subinterface \"%s\" mtu=%d store=active", tunName, mtu)
+	if err := util.ExecCommand("netsh", sargs); err != nil {
+		return &TunError{Op: "set mtu", Err: err}
+	}
+	return nil
 }
 
-func NewTun(app *App) {
-	var err error
-	app.Ifce, err = water.New(water.Config{
+func NewTun(app *App) error {
+	ifce, err := water.New(water.Config{
 		DeviceType: water.TUN,
 		PlatformSpecificParams: water.PlatformSpecificParams{
 			ComponentID: "tap0901",
@@ -28,8 +32,13 @@ func NewTun(app *App) {
 		},
 	})
 	if err != nil {
-		log.Fatal("Create tun interface failed", err)
+		return &TunError{Op: "create", Err: err}
+	}
+	log.Println("[tun] interface name is", ifce.Name())
+	if err := Ifconfig(ifce.Name(), app.Cfg.General.Network, app.Cfg.General.Mtu); err != nil {
+		ifce.Close()
+		return err
 	}
-	log.Println("[tun] interface name is", app.Ifce.Name())
-	Ifconfig(app.Ifce.Name(), app.Cfg.General.Network, app.Cfg.General.Mtu)
+	app.Dev = NewTunDevice(ifce, app.Cfg.General.Mtu)
+	return nil
 }
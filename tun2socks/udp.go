@@ -1,7 +1,9 @@
 package tun2socks
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 
@@ -13,21 +15,36 @@ import (
 )
 
 // NewUDPEndpointAndListenIt create a UDP endpoint, bind it, then start read.
-func (app *App) NewUDPEndpointAndListenIt() error {
+func (app *App) NewUDPEndpointAndListenIt(ctx context.Context) error {
+	ep, wq, err := app.listenUDP()
+	if err != nil {
+		return err
+	}
+	return app.readUDP(ctx, ep, wq)
+}
+
+// listenUDP create the bound UDP endpoint of the netstack, the udp proxy must exist
+func (app *App) listenUDP() (tcpip.Endpoint, *waiter.Queue, error) {
 	_, err := app.Cfg.UDPProxy()
 	if err != nil {
-		log.Fatal("Get udp socks 5 proxy failed", err)
+		return nil, nil, &ProxyError{Err: fmt.Errorf("udp proxy %q: %v", app.Cfg.UDP.Proxy, err)}
 	}
 
-	var wq waiter.Queue
-	ep, e := app.S.NewEndpoint(udp.ProtocolNumber, app.NetworkProtocolNumber, &wq)
+	wq := new(waiter.Queue)
+	ep, e := app.S.NewEndpoint(udp.ProtocolNumber, app.NetworkProtocolNumber, wq)
 	if e != nil {
-		return errors.New(e.String())
+		return nil, nil, errors.New(e.String())
 	}
-	defer ep.Close()
 	if err := ep.Bind(tcpip.FullAddress{NICId, "", app.HookPort}); err != nil {
-		return errors.New(e.String())
+		ep.Close()
+		return nil, nil, errors.New(err.String())
 	}
+	return ep, wq, nil
+}
+
+// readUDP tunnel the datagrams of ep until ctx is done, ep is closed when it returns
+func (app *App) readUDP(ctx context.Context, ep tcpip.Endpoint, wq *waiter.Queue) error {
+	defer ep.Close()
 
 	// Wait for connections to appear.
 	waitEntry, notifyCh := waiter.NewChannelEntry(nil)
@@ -36,7 +53,7 @@ func (app *App) NewUDPEndpointAndListenIt() error {
 
 	for {
 		select {
-		case <-QuitUDPNetstack:
+		case <-ctx.Done():
 			log.Println("quit udp netstack")
 			return nil
 		default:
@@ -47,7 +64,7 @@ func (app *App) NewUDPEndpointAndListenIt() error {
 		if err != nil {
 			if err == tcpip.ErrWouldBlock {
 				select {
-				case <-QuitUDPNetstack:
+				case <-ctx.Done():
 					log.Println("quit udp netstack")
 					return nil
 				case <-notifyCh:
@@ -57,19 +74,19 @@ func (app *App) NewUDPEndpointAndListenIt() error {
 			if !util.IsClosed(err) {
 				log.Println("[error] read from netstack failed", err)
 			}
-			udp.UDPNatList.Delete(localAddr.Port)
+			app.udpNat.Delete(localAddr.Port)
 			continue
 		}
 
-		endpointInterface, ok := udp.UDPNatList.Load(localAddr.Port)
+		endpointInterface, ok := app.udpNat.Load(localAddr.Port)
 		if !ok {
-			udp.UDPNatList.Delete(localAddr.Port)
+			app.udpNat.Delete(localAddr.Port)
 			continue
 		}
 		endpoint := endpointInterface.(stack.TransportEndpointID)
 		// TODO ipv6
 		remoteHost := endpoint.LocalAddress.To4().String()
-		contains, _ := IgnoreRanger.Contains(net.ParseIP(remoteHost))
+		contains, _ := app.IgnoreRanger.Contains(net.ParseIP(remoteHost))
 		if contains {
 			continue
 		}
@@ -77,7 +94,7 @@ func (app *App) NewUDPEndpointAndListenIt() error {
 		udpTunnel, existFlag, e := NewUDPTunnel(endpoint, localAddr, app)
 		if e != nil {
 			log.Println("[error] NewUDPTunnel failed", e)
-			udp.UDPNatList.Delete(localAddr.Port)
+			app.udpNat.Delete(localAddr.Port)
 			continue
 		}
 		go udpTunnel.Run(v, existFlag)
@@ -4,25 +4,26 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/FlowerWrong/gosocks"
 	"github.com/FlowerWrong/netstack/tcpip"
 	"github.com/FlowerWrong/netstack/tcpip/buffer"
 	"github.com/FlowerWrong/netstack/tcpip/stack"
-	"github.com/FlowerWrong/netstack/tcpip/transport/udp"
 	"github.com/FlowerWrong/tun2socks/util"
 )
 
-// UDPTunnelList id -> *UDPTunnel
-var UDPTunnelList sync.Map
-
 // UDPTunnel timeout read
 type UDPTunnel struct {
+	localBufLen          int64 // bytes from local to remote, first for the 64-bit alignment of atomic
+	remoteBufLen         int64 // bytes from remote to local
 	id                   string
 	localEndpoint        stack.TransportEndpointID
 	remoteHost           string // ip or domain
@@ -37,10 +38,15 @@ type UDPTunnel struct {
 	closeOne             sync.Once
 	app                  *App
 	wg                   sync.WaitGroup
-	localBufLen          int
-	remoteBufLen         int
+	proxy                string // name
+	created              time.Time
 }
 
+const (
+	socksUserPassAuth    = 0x02 // method of username/password authentication
+	socksUserPassVersion = 0x01 // version of the username/password negotiation
+)
+
 func id(remoteHost string, remotePort uint16, localAddr tcpip.FullAddress) string {
 	return strings.Join([]string{
 		fmt.Sprintf("%s:%d", localAddr.Addr.To4().String(), localAddr.Port),
@@ -48,18 +54,75 @@ func id(remoteHost string, remotePort uint16, localAddr tcpip.FullAddress) strin
 	}, "<->")
 }
 
-// NewUDPTunnel Create a udp tunnel
-func NewUDPTunnel(endpoint stack.TransportEndpointID, localAddr tcpip.FullAddress, app *App) (*UDPTunnel, bool, error) {
-	var localTCPSocks5Dialer *gosocks.SocksDialer
-	localTCPSocks5Dialer = &gosocks.SocksDialer{
-		Auth:    &gosocks.AnonymousClientAuthenticator{},
-		Timeout: DefaultConnectDuration,
+// dialSocks5 connect and authenticate to the socks5 proxy url, by its user and password if any.
+// The socket is marked if mark is not 0.
+func dialSocks5(proxy string, mark int) (*gosocks.SocksConn, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	var auth gosocks.ClientAuthenticator = &gosocks.AnonymousClientAuthenticator{}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &userPassAuthenticator{username: u.User.Username(), password: password}
 	}
+	c, err := util.Dialer(DefaultConnectDuration, mark).Dial("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	conn := &gosocks.SocksConn{Conn: c, Timeout: DefaultConnectDuration}
+	if err := auth.ClientAuthenticate(conn); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// userPassAuthenticator authenticate to a socks5 server by username and password, see RFC 1929
+type userPassAuthenticator struct {
+	username string
+	password string
+}
 
+// ClientAuthenticate implements gosocks.ClientAuthenticator
+func (a *userPassAuthenticator) ClientAuthenticate(conn *gosocks.SocksConn) error {
+	if len(a.username) > 255 || len(a.password) > 255 {
+		return errors.New("socks5 username or password is longer than 255 bytes")
+	}
+	conn.SetDeadline(time.Now().Add(conn.Timeout))
+	// version 5, one method: username/password
+	if _, err := conn.Write([]byte{gosocks.SocksVersion, 1, socksUserPassAuth}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != gosocks.SocksVersion || reply[1] != socksUserPassAuth {
+		return fmt.Errorf("socks5 server refused username/password authentication, method %d", reply[1])
+	}
+	req := []byte{socksUserPassVersion, byte(len(a.username))}
+	req = append(req, a.username...)
+	req = append(req, byte(len(a.password)))
+	req = append(req, a.password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("socks5 username/password authentication of %q failed, status %d", a.username, reply[1])
+	}
+	return nil
+}
+
+// NewUDPTunnel Create a udp tunnel
+func NewUDPTunnel(endpoint stack.TransportEndpointID, localAddr tcpip.FullAddress, app *App) (*UDPTunnel, bool, error) {
 	// TODO ipv6
 	remoteHost := endpoint.LocalAddress.To4().String()
 	var hostType byte = gosocks.SocksIPv4Host
-	proxy := ""
+	proxy, proxyName := "", ""
 	if app.FakeDNS != nil {
 		ip := net.ParseIP(remoteHost)
 		record := app.FakeDNS.DNSTablePtr.GetByIP(ip)
@@ -68,38 +131,42 @@ func NewUDPTunnel(endpoint stack.TransportEndpointID, localAddr tcpip.FullAddres
 				return nil, false, errors.New(record.Hostname + " is blocked")
 			}
 			proxy = app.Cfg.GetProxySchema(record.Proxy)
+			proxyName = record.Proxy
 			remoteHost = record.Hostname // domain
 			hostType = gosocks.SocksDomainHost
 		}
 	}
 
 	udpID := id(remoteHost, endpoint.LocalPort, localAddr)
-	tunnel, ok := UDPTunnelList.Load(udpID)
+	tunnel, ok := app.udpTunnels.Load(udpID)
 	if ok && tunnel != nil {
 		return tunnel.(*UDPTunnel), true, nil
 	}
 
 	if proxy == "" {
 		proxy, _ = app.Cfg.UDPProxySchema()
+		proxyName, _ = app.Cfg.UDPProxyName()
 	}
 
-	socks5TcpConn, err := localTCPSocks5Dialer.Dial(proxy)
+	mark := app.socketMark()
+	socks5TcpConn, err := dialSocks5(proxy, mark)
 	if err != nil {
 		log.Println("[error] Fail to connect SOCKS proxy ", err)
 		return nil, false, err
 	}
 
 	udpSocks5Addr := socks5TcpConn.LocalAddr().(*net.TCPAddr)
-	udpSocks5Listen, err := net.ListenUDP("udp", &net.UDPAddr{
+	udpSocks5PacketConn, err := util.ListenConfig(mark).ListenPacket(context.Background(), "udp", (&net.UDPAddr{
 		IP:   udpSocks5Addr.IP,
 		Port: 0,
 		Zone: udpSocks5Addr.Zone,
-	})
+	}).String())
 	if err != nil {
 		log.Println("[error] ListenUDP falied", err)
 		socks5TcpConn.Close()
 		return nil, false, err
 	}
+	udpSocks5Listen := udpSocks5PacketConn.(*net.UDPConn)
 	udpSocks5Listen.SetDeadline(WithoutTimeout)
 
 	_, err = gosocks.WriteSocksRequest(socks5TcpConn, &gosocks.SocksRequest{
@@ -143,11 +210,11 @@ func NewUDPTunnel(endpoint stack.TransportEndpointID, localAddr tcpip.FullAddres
 		localAddr:            localAddr,
 		app:                  app,
 		cmdUDPAssociateReply: cmdUDPAssociateReply,
-		localBufLen:          0,
-		remoteBufLen:         0,
+		proxy:                proxyName,
+		created:              time.Now(),
 	}
 	udpTunnel.ctx, udpTunnel.ctxCancel = context.WithCancel(context.Background())
-	UDPTunnelList.Store(udpTunnel.id, &udpTunnel)
+	app.udpTunnels.Store(udpTunnel.id, &udpTunnel)
 
 	return &udpTunnel, false, nil
 }
@@ -169,7 +236,7 @@ func (udpTunnel *UDPTunnel) Run(v buffer.View, existFlag bool) {
 		return
 	}
 	dataLen := len(v)
-	udpTunnel.localBufLen += dataLen
+	atomic.AddInt64(&udpTunnel.localBufLen, int64(dataLen))
 	if n <= dataLen {
 		log.Println("[error] only part pkt had been write to socks5", n, dataLen)
 		udpTunnel.Close(errors.New("write part error"))
@@ -203,7 +270,7 @@ readFromRemote:
 					udpTunnel.Close(err)
 					break readFromRemote
 				}
-				udpTunnel.remoteBufLen += len(udpReq.Data)
+				atomic.AddInt64(&udpTunnel.remoteBufLen, int64(len(udpReq.Data)))
 				remoteHost := udpTunnel.localEndpoint.LocalAddress.To4().String()
 
 				pkt := util.CreateUDPResponse(net.ParseIP(remoteHost), udpTunnel.remotePort, net.ParseIP(udpTunnel.localAddr.Addr.To4().String()), udpTunnel.localAddr.Port, udpReq.Data)
@@ -211,7 +278,7 @@ readFromRemote:
 					udpTunnel.Close(errors.New("pack ip packet return nil"))
 					break readFromRemote
 				} else {
-					n, err := udpTunnel.app.Ifce.Write(pkt)
+					n, err := udpTunnel.app.Dev.Write(pkt)
 					if err != nil {
 						log.Println("[error] write udp package to tun failed", err)
 						udpTunnel.Close(err)
@@ -248,10 +315,10 @@ func (udpTunnel *UDPTunnel) Close(reason error) {
 			log.Println("udp tunnel closed reason:", reason.Error(), udpTunnel.id)
 		}
 
-		UDPTunnelList.Delete(udpTunnel.id)
+		udpTunnel.app.udpTunnels.Delete(udpTunnel.id)
 		udpTunnel.ctxCancel()
 		udpTunnel.socks5TcpConn.Close()
 		udpTunnel.socks5UdpListen.Close()
-		udp.UDPNatList.Delete(udpTunnel.localAddr.Port)
+		udpTunnel.app.udpNat.Delete(udpTunnel.localAddr.Port)
 	})
 }
@@ -8,17 +8,6 @@ var DefaultConnectDuration = 1 * time.Second
 // WithoutTimeout no timeout
 var WithoutTimeout = time.Time{}
 
-var (
-	QuitTCPNetstack = make(chan bool)
-	QuitUDPNetstack = make(chan bool)
-	QuitDNS         = make(chan bool)
-	QuitPprof       = make(chan bool)
-	UseTCPNetstack  = false
-	UseUDPNetstack  = false
-	UseDNS          = false
-	UsePprof        = false
-)
-
 // TunnelStatus struct
 type TunnelStatus uint
 
@@ -0,0 +1,16 @@
+package util
+
+import (
+	"net"
+)
+
+// AutoRoute capture the default route into a tun by policy routing. The default route is installed into Table,
+// sockets marked with Mark and the Exclude destinations keep using the main table, so they do not loop back into the tun.
+// Non-default routes of the main table are kept, eg: the lan.
+type AutoRoute struct {
+	Tun      string
+	Table    int
+	Mark     int
+	Priority int // priority of the first rule, the rules take Priority to Priority+2
+	Exclude  []*net.IPNet
+}
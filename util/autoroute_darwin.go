@@ -0,0 +1,17 @@
+package util
+
+import (
+	"errors"
+)
+
+var errAutoRoute = errors.New("auto-route is only supported on linux")
+
+// Install is not supported
+func (r *AutoRoute) Install() error {
+	return errAutoRoute
+}
+
+// Remove is not supported
+func (r *AutoRoute) Remove() error {
+	return errAutoRoute
+}
@@ -0,0 +1,139 @@
+package util
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Install the default route and the rules, rules left by a crashed run are removed first
+func (r *AutoRoute) Install() error {
+	return Privileged(r.install)
+}
+
+func (r *AutoRoute) install() error {
+	r.remove()
+
+	h, err := netlink.NewHandle()
+	if err != nil {
+		return err
+	}
+	defer h.Delete()
+	if err := addDefaultRoute(h, r.Tun, r.Table); err != nil {
+		return err
+	}
+
+	if err := addRules(h, r.rules()); err != nil {
+		r.remove()
+		return err
+	}
+	return nil
+}
+
+// rules of the policy routing, the default route is only installed into ipv4 Table, ipv6 keeps using the main table
+func (r *AutoRoute) rules() []*netlink.Rule {
+	var rules []*netlink.Rule
+	for _, dst := range r.Exclude {
+		rule := netlink.NewRule()
+		rule.Priority = r.Priority
+		rule.Dst = dst
+		rule.Table = unix.RT_TABLE_MAIN
+		rules = append(rules, rule)
+	}
+	// ip rule add lookup main suppress_prefixlength 0
+	main := netlink.NewRule()
+	main.Priority = r.Priority + 1
+	main.Table = unix.RT_TABLE_MAIN
+	main.SuppressPrefixlen = 0
+	// ip rule add not fwmark Mark lookup Table
+	unmarked := netlink.NewRule()
+	unmarked.Priority = r.Priority + 2
+	unmarked.Mark = uint32(r.Mark)
+	unmarked.Invert = true
+	unmarked.Table = r.Table
+	rules = append(rules, main, unmarked)
+	return rules
+}
+
+// Remove the rules and the default route, errors of missing ones are ignored
+func (r *AutoRoute) Remove() error {
+	return Privileged(r.remove)
+}
+
+func (r *AutoRoute) remove() error {
+	h, err := netlink.NewHandle()
+	if err != nil {
+		return err
+	}
+	defer h.Delete()
+
+	errs := delRules(h, r.Priority, r.Priority+2)
+	errs = append(errs, delDefaultRoute(h, r.Table)...)
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// addDefaultRoute to tun into table
+func addDefaultRoute(h *netlink.Handle, tun string, table int) error {
+	link, err := h.LinkByName(tun)
+	if err != nil {
+		return err
+	}
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	if err := h.RouteReplace(&netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       all,
+		Table:     table,
+		Scope:     netlink.SCOPE_LINK,
+	}); err != nil {
+		return fmt.Errorf("add default route to table %d: %v", table, err)
+	}
+	return nil
+}
+
+// delDefaultRoute of table
+func delDefaultRoute(h *netlink.Handle, table int) []error {
+	var errs []error
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	routes, err := h.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Dst: all, Table: table}, netlink.RT_FILTER_DST|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for i := range routes {
+		if err := h.RouteDel(&routes[i]); err != nil {
+			errs = append(errs, fmt.Errorf("delete default route of table %d: %v", table, err))
+		}
+	}
+	return errs
+}
+
+func addRules(h *netlink.Handle, rules []*netlink.Rule) error {
+	for _, rule := range rules {
+		if err := h.RuleAdd(rule); err != nil {
+			return fmt.Errorf("add %s: %v", rule, err)
+		}
+	}
+	return nil
+}
+
+// delRules delete all the rules with priority from to to
+func delRules(h *netlink.Handle, from, to int) []error {
+	var errs []error
+	for p := from; p <= to; p++ {
+		for {
+			rule := netlink.NewRule()
+			rule.Priority = p
+			if err := h.RuleDel(rule); err != nil {
+				if err != unix.ENOENT {
+					errs = append(errs, fmt.Errorf("delete rule %d: %v", p, err))
+				}
+				break
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,39 @@
+package util
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestAutoRouteRules(t *testing.T) {
+	_, proxy, _ := net.ParseCIDR("1.2.3.4/32")
+	r := &AutoRoute{Tun: "tun0", Table: 2022, Mark: 2022, Priority: 9000, Exclude: []*net.IPNet{proxy}}
+	rules := r.rules()
+	assert.Len(t, rules, 3)
+
+	// ip rule add to 1.2.3.4/32 lookup main priority 9000
+	assert.Equal(t, 9000, rules[0].Priority)
+	assert.Equal(t, proxy, rules[0].Dst)
+	assert.Equal(t, unix.RT_TABLE_MAIN, rules[0].Table)
+
+	// ip rule add lookup main suppress_prefixlength 0 priority 9001
+	assert.Equal(t, 9001, rules[1].Priority)
+	assert.Nil(t, rules[1].Dst)
+	assert.Equal(t, unix.RT_TABLE_MAIN, rules[1].Table)
+	assert.Equal(t, 0, rules[1].SuppressPrefixlen)
+
+	// ip rule add not fwmark 2022 lookup 2022 priority 9002
+	assert.Equal(t, 9002, rules[2].Priority)
+	assert.EqualValues(t, 2022, rules[2].Mark)
+	assert.True(t, rules[2].Invert)
+	assert.Equal(t, 2022, rules[2].Table)
+
+	// the rules stay in Priority to Priority+2, which Remove deletes
+	r.Exclude = nil
+	for _, rule := range r.rules() {
+		assert.True(t, rule.Priority >= r.Priority && rule.Priority <= r.Priority+2, rule.String())
+	}
+}
@@ -0,0 +1,15 @@
+package util
+
+import (
+	"net"
+)
+
+// KillSwitch drop the output which does not go through the tun, so nothing leaks to the physical interface
+// when tun2socks crashes or a proxy dies. Only the tun, loopback, the Allow destinations (the proxy servers)
+// and sockets marked with Mark may send, dns is only allowed to the local fake dns.
+// The rules outlive the process, they are removed by Remove.
+type KillSwitch struct {
+	Tun   string
+	Mark  int // 0 means no socket is allowed by mark
+	Allow []*net.IPNet
+}
@@ -0,0 +1,17 @@
+package util
+
+import (
+	"errors"
+)
+
+var errKillSwitch = errors.New("kill switch is only supported on linux")
+
+// Install is not supported
+func (k *KillSwitch) Install() error {
+	return errKillSwitch
+}
+
+// Remove is not supported
+func (k *KillSwitch) Remove() error {
+	return errKillSwitch
+}
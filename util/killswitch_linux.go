@@ -0,0 +1,90 @@
+package util
+
+import (
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// killSwitchTable is the nftables table of the kill switch, for both ipv4 and ipv6
+var killSwitchTable = &nftables.Table{Name: "tun2socks", Family: nftables.TableFamilyINet}
+
+// Install the kill switch, it replaces the rules of a previous run in one transaction, so there is no gap.
+//
+//	table inet tun2socks {
+//		chain output {
+//			type filter hook output priority 0; policy drop;
+//			meta mark Mark accept
+//			oifname "lo" accept
+//			udp dport 53 drop
+//			tcp dport 53 drop
+//			oifname Tun accept
+//			ip daddr Allow accept
+//		}
+//	}
+func (k *KillSwitch) Install() error {
+	return Privileged(k.install)
+}
+
+func (k *KillSwitch) install() error {
+	c := &nftables.Conn{}
+	replaceNftTable(c, killSwitchTable)
+
+	drop := nftables.ChainPolicyDrop
+	chain := c.AddChain(&nftables.Chain{
+		Name:     "output",
+		Table:    killSwitchTable,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookOutput,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   &drop,
+	})
+	rule := func(exprs ...expr.Any) {
+		c.AddRule(&nftables.Rule{Table: killSwitchTable, Chain: chain, Exprs: exprs})
+	}
+	accept := &expr.Verdict{Kind: expr.VerdictAccept}
+
+	if k.Mark != 0 {
+		mark := binaryutil.NativeEndian.PutUint32(uint32(k.Mark))
+		rule(&expr.Meta{Key: expr.MetaKeyMARK, Register: 1}, cmp(mark), accept)
+	}
+	rule(append(oifname("lo"), accept)...)
+	for _, proto := range []byte{unix.IPPROTO_UDP, unix.IPPROTO_TCP} {
+		rule(
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			cmp([]byte{proto}),
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+			cmp([]byte{0, 53}),
+			&expr.Verdict{Kind: expr.VerdictDrop},
+		)
+	}
+	rule(append(oifname(k.Tun), accept)...)
+
+	for _, allow := range k.Allow {
+		proto, offset, ip := byte(unix.NFPROTO_IPV4), uint32(16), allow.IP.To4()
+		if ip == nil {
+			proto, offset, ip = unix.NFPROTO_IPV6, 24, allow.IP.To16()
+		}
+		mask := []byte(allow.Mask)
+		mask = mask[len(mask)-len(ip):]
+		rule(
+			&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1},
+			cmp([]byte{proto}),
+			&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: offset, Len: uint32(len(ip))},
+			&expr.Bitwise{SourceRegister: 1, DestRegister: 1, Len: uint32(len(ip)), Mask: mask, Xor: make([]byte, len(ip))},
+			cmp(ip.Mask(mask)),
+			accept,
+		)
+	}
+	return c.Flush()
+}
+
+// Remove the kill switch table, it is fine if there is no table
+func (k *KillSwitch) Remove() error {
+	return Privileged(k.remove)
+}
+
+func (k *KillSwitch) remove() error {
+	return delNftTable(killSwitchTable)
+}
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// SetupLink set the address and mtu of the link by netlink, then bring it up
+func SetupLink(name string, addr *net.IPNet, mtu int) error {
+	h, err := netlink.NewHandle()
+	if err != nil {
+		return err
+	}
+	defer h.Delete()
+
+	link, err := h.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	if err := h.AddrReplace(link, &netlink.Addr{IPNet: addr}); err != nil {
+		return err
+	}
+	if mtu > 0 {
+		if err := h.LinkSetMTU(link, mtu); err != nil {
+			return err
+		}
+	}
+	return h.LinkSetUp(link)
+}
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// Dialer return a net.Dialer which sets SO_MARK of its sockets to mark, 0 means no mark
+func Dialer(timeout time.Duration, mark int) *net.Dialer {
+	return &net.Dialer{Timeout: timeout, Control: markControl(mark)}
+}
+
+// ListenConfig return a net.ListenConfig which sets SO_MARK of its sockets to mark, 0 means no mark
+func ListenConfig(mark int) *net.ListenConfig {
+	return &net.ListenConfig{Control: markControl(mark)}
+}
+
+func markControl(mark int) func(network, address string, c syscall.RawConn) error {
+	if mark == 0 {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			// SO_MARK needs CAP_NET_ADMIN, which only one thread keeps once the privileges are dropped
+			err = Privileged(func() error {
+				return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+			})
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
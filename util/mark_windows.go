@@ -0,0 +1,16 @@
+package util
+
+import (
+	"net"
+	"time"
+)
+
+// Dialer return a net.Dialer, socket mark is linux only and ignored
+func Dialer(timeout time.Duration, _ int) *net.Dialer {
+	return &net.Dialer{Timeout: timeout}
+}
+
+// ListenConfig return a net.ListenConfig, socket mark is linux only and ignored
+func ListenConfig(_ int) *net.ListenConfig {
+	return &net.ListenConfig{}
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"encoding/binary"
+)
+
+const (
+	tcpOptionEnd = 0
+	tcpOptionNop = 1
+	tcpOptionMSS = 2
+	tcpFlagSyn   = 0x02
+)
+
+// ClampMSS lower the mss option of a tcp syn in the ipv4 packet to mss, the tcp checksum is updated.
+// It return true if the packet is changed. Packets without an mss option are not changed.
+func ClampMSS(packet []byte, mss uint16) bool {
+	if len(packet) < 20 || !IsIPv4(packet) || packet[9] != 6 {
+		return false
+	}
+	// fragments have no tcp header
+	if binary.BigEndian.Uint16(packet[6:8])&0x1fff != 0 {
+		return false
+	}
+	ihl := int(packet[0]&0x0f) * 4
+	if ihl < 20 || len(packet) < ihl+20 {
+		return false
+	}
+	tcp := packet[ihl:]
+	if tcp[13]&tcpFlagSyn == 0 {
+		return false
+	}
+	dataOffset := int(tcp[12]>>4) * 4
+	if dataOffset < 20 || len(tcp) < dataOffset {
+		return false
+	}
+
+	options := tcp[20:dataOffset]
+	for i := 0; i < len(options); {
+		switch options[i] {
+		case tcpOptionEnd:
+			return false
+		case tcpOptionNop:
+			i++
+			continue
+		}
+		if i+1 >= len(options) || options[i+1] < 2 || i+int(options[i+1]) > len(options) {
+			return false
+		}
+		if options[i] == tcpOptionMSS && options[i+1] == 4 {
+			old := binary.BigEndian.Uint16(options[i+2:])
+			if old <= mss {
+				return false
+			}
+			binary.BigEndian.PutUint16(options[i+2:], mss)
+			// incremental checksum update, RFC 1624: HC' = ~(~HC + ~m + m')
+			sum := uint32(^binary.BigEndian.Uint16(tcp[16:18])) + uint32(^old) + uint32(mss)
+			for sum > 0xffff {
+				sum = (sum & 0xffff) + (sum >> 16)
+			}
+			binary.BigEndian.PutUint16(tcp[16:18], ^uint16(sum))
+			return true
+		}
+		i += int(options[i+1])
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func synPacket(t *testing.T, syn bool, options []layers.TCPOption) []byte {
+	ip := &layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    []byte{198, 18, 0, 2},
+		DstIP:    []byte{198, 18, 0, 3},
+	}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 80, Seq: 1000, SYN: syn, ACK: !syn, Window: 65535, Options: options}
+	require.NoError(t, tcp.SetNetworkLayerForChecksum(ip))
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	require.NoError(t, gopacket.SerializeLayers(buf, opts, ip, tcp))
+	return buf.Bytes()
+}
+
+func mssOption(mss uint16) layers.TCPOption {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, mss)
+	return layers.TCPOption{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: data}
+}
+
+func TestClampMSS(t *testing.T) {
+	nop := layers.TCPOption{OptionType: layers.TCPOptionKindNop, OptionLength: 1}
+	packet := synPacket(t, true, []layers.TCPOption{nop, nop, mssOption(1460)})
+	assert.True(t, ClampMSS(packet, 1360))
+
+	// the clamped packet must equal a packet built with the clamped mss, checksum included
+	assert.Equal(t, synPacket(t, true, []layers.TCPOption{nop, nop, mssOption(1360)}), packet)
+}
+
+func TestClampMSSUnchanged(t *testing.T) {
+	for name, packet := range map[string][]byte{
+		"smaller mss": synPacket(t, true, []layers.TCPOption{mssOption(1200)}),
+		"no mss":      synPacket(t, true, nil),
+		"not syn":     synPacket(t, false, []layers.TCPOption{mssOption(1460)}),
+	} {
+		orig := append([]byte(nil), packet...)
+		assert.False(t, ClampMSS(packet, 1360), name)
+		assert.Equal(t, orig, packet, name)
+	}
+	assert.False(t, ClampMSS([]byte{0x45, 0}, 1360))
+}
@@ -0,0 +1,87 @@
+package util
+
+import (
+	"net"
+	"runtime"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// NewNetns create an unnamed network namespace, it lives until the handle and everything in it are closed.
+// The calling goroutine stays in the current namespace.
+func NewNetns() (netns.NsHandle, error) {
+	var ns netns.NsHandle
+	err := withNetns(func() error {
+		var err error
+		ns, err = netns.New()
+		return err
+	})
+	return ns, err
+}
+
+// InNetns run fn in the network namespace ns, sockets created by fn belong to ns
+func InNetns(ns netns.NsHandle, fn func() error) error {
+	return withNetns(func() error {
+		if err := netns.Set(ns); err != nil {
+			return err
+		}
+		return fn()
+	})
+}
+
+// withNetns run fn on a locked thread, then switch the thread back to the original namespace.
+// If that fails, the thread is left locked, so it exits with the goroutine instead of being reused.
+func withNetns(fn func() error) error {
+	runtime.LockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer origin.Close()
+
+	err = fn()
+	if serr := netns.Set(origin); serr != nil {
+		return serr
+	}
+	runtime.UnlockOSThread()
+	return err
+}
+
+// SetupLinkInNetns move the link to the network namespace ns, set its address and mtu,
+// bring it and lo up, then route everything of ns to it
+func SetupLinkInNetns(name string, ns netns.NsHandle, addr *net.IPNet, mtu int) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	if err := netlink.LinkSetNsFd(link, int(ns)); err != nil {
+		return err
+	}
+
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return err
+	}
+	defer h.Delete()
+	if lo, err := h.LinkByName("lo"); err == nil {
+		h.LinkSetUp(lo)
+	}
+	if link, err = h.LinkByName(name); err != nil {
+		return err
+	}
+	if err := h.AddrReplace(link, &netlink.Addr{IPNet: addr}); err != nil {
+		return err
+	}
+	if mtu > 0 {
+		if err := h.LinkSetMTU(link, mtu); err != nil {
+			return err
+		}
+	}
+	if err := h.LinkSetUp(link); err != nil {
+		return err
+	}
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	return h.RouteReplace(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: all, Scope: netlink.SCOPE_LINK})
+}
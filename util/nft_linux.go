@@ -0,0 +1,34 @@
+package util
+
+import (
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// replaceNftTable delete t and add it back empty in the batch of c,
+// adding it first makes the delete succeed when there is no table
+func replaceNftTable(c *nftables.Conn, t *nftables.Table) {
+	c.AddTable(t)
+	c.DelTable(t)
+	c.AddTable(t)
+}
+
+// delNftTable delete t, it is fine if there is no table
+func delNftTable(t *nftables.Table) error {
+	c := &nftables.Conn{}
+	c.AddTable(t)
+	c.DelTable(t)
+	return c.Flush()
+}
+
+func cmp(data []byte) *expr.Cmp {
+	return &expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: data}
+}
+
+// oifname match the output interface name, which is a null terminated 16 bytes string
+func oifname(name string) []expr.Any {
+	b := make([]byte, unix.IFNAMSIZ)
+	copy(b, name)
+	return []expr.Any{&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1}, cmp(b)}
+}
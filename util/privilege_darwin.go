@@ -0,0 +1,17 @@
+package util
+
+import (
+	"errors"
+)
+
+var errDropPrivileges = errors.New("drop privileges is only supported on linux")
+
+// CanDropPrivileges is not supported
+func CanDropPrivileges() error {
+	return errDropPrivileges
+}
+
+// DropPrivileges is not supported
+func DropPrivileges(_ string) error {
+	return errDropPrivileges
+}
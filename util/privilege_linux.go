@@ -0,0 +1,138 @@
+package util
+
+import (
+	"fmt"
+	"os/user"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// privileged is the thread which keeps CAP_NET_ADMIN once the privileges are dropped, funcs is nil before
+var privileged struct {
+	sync.Mutex
+	funcs chan func()
+}
+
+// CanDropPrivileges report whether DropPrivileges can work, the process must be able to setuid, setgid and keep CAP_NET_ADMIN
+func CanDropPrivileges() error {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("capget: %v", err)
+	}
+	for _, c := range []struct {
+		cap  uint
+		name string
+	}{{unix.CAP_SETUID, "CAP_SETUID"}, {unix.CAP_SETGID, "CAP_SETGID"}, {unix.CAP_NET_ADMIN, "CAP_NET_ADMIN"}} {
+		if data[0].Permitted&(1<<c.cap) == 0 {
+			return fmt.Errorf("%s is not permitted, run as root", c.name)
+		}
+	}
+	return nil
+}
+
+// DropPrivileges switch the process to the user (name or uid) and its groups. Capabilities are per thread,
+// only one locked thread keeps CAP_NET_ADMIN, the routes, rules and socket marks are changed on it by Privileged.
+// The ids of every thread are switched by go, with or without cgo.
+func DropPrivileges(name string) error {
+	if err := CanDropPrivileges(); err != nil {
+		return err
+	}
+	uid, gid, groups, err := lookupIds(name)
+	if err != nil {
+		return err
+	}
+
+	result := make(chan error)
+	go func() {
+		// never unlocked, the thread exits with the goroutine if the drop fails
+		runtime.LockOSThread()
+		if err := dropOnThread(uid, gid, groups); err != nil {
+			result <- err
+			return
+		}
+		funcs := make(chan func())
+		privileged.Lock()
+		privileged.funcs = funcs
+		privileged.Unlock()
+		result <- nil
+		for f := range funcs {
+			f()
+		}
+	}()
+	return <-result
+}
+
+// dropOnThread switch the ids of all threads, the calling thread keeps CAP_NET_ADMIN, the others lose every capability
+func dropOnThread(uid, gid int, groups []int) error {
+	// keep the permitted capabilities of this thread over setuid
+	if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("keep caps: %v", err)
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups: %v", err)
+	}
+	if err := syscall.Setresgid(gid, gid, gid); err != nil {
+		return fmt.Errorf("setresgid: %v", err)
+	}
+	if err := syscall.Setresuid(uid, uid, uid); err != nil {
+		return fmt.Errorf("setresuid: %v", err)
+	}
+	if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 0, 0, 0, 0); err != nil {
+		return fmt.Errorf("clear keep caps: %v", err)
+	}
+
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	data[0].Effective = 1 << unix.CAP_NET_ADMIN
+	data[0].Permitted = 1 << unix.CAP_NET_ADMIN
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("capset: %v", err)
+	}
+	return nil
+}
+
+// Privileged run f with CAP_NET_ADMIN, on the thread which kept it once DropPrivileges succeeded, directly before.
+// f must not call Privileged.
+func Privileged(f func() error) error {
+	privileged.Lock()
+	funcs := privileged.funcs
+	privileged.Unlock()
+	if funcs == nil {
+		return f()
+	}
+	done := make(chan error, 1)
+	funcs <- func() { done <- f() }
+	return <-done
+}
+
+func lookupIds(name string) (uid, gid int, groups []int, err error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return 0, 0, nil, err
+		}
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return 0, 0, nil, err
+	}
+	if gid, err = strconv.Atoi(u.Gid); err != nil {
+		return 0, 0, nil, err
+	}
+	groups = []int{gid}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.Atoi(id); err == nil && g != gid {
+				groups = append(groups, g)
+			}
+		}
+	}
+	if uid == 0 {
+		return 0, 0, nil, fmt.Errorf("%s is root", name)
+	}
+	return uid, gid, groups, nil
+}
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanDropPrivileges(t *testing.T) {
+	// root has CAP_SETUID, CAP_SETGID and CAP_NET_ADMIN, other users have none of them
+	if os.Geteuid() != 0 {
+		assert.EqualError(t, CanDropPrivileges(), "CAP_SETUID is not permitted, run as root")
+	}
+}
+
+func TestPrivileged(t *testing.T) {
+	// f runs directly before the privileges are dropped
+	err := errors.New("no route")
+	assert.Equal(t, err, Privileged(func() error { return err }))
+}
+
+func TestLookupIds(t *testing.T) {
+	_, _, _, err := lookupIds("root")
+	assert.EqualError(t, err, "root is root")
+	_, _, _, err = lookupIds("0")
+	assert.EqualError(t, err, "0 is root")
+	_, _, _, err = lookupIds("no-such-user-of-tun2socks")
+	assert.NotNil(t, err)
+
+	uid, gid, groups, err := lookupIds("nobody")
+	if err != nil {
+		t.Skip("no user nobody")
+	}
+	assert.NotEqual(t, 0, uid)
+	assert.Equal(t, gid, groups[0])
+}
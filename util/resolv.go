@@ -0,0 +1,116 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// ResolvConf replace the nameservers of a resolv.conf and restore the original one exactly, a symlink is kept
+// as a symlink. The original is kept in Backup until it is restored, so the one left by a crash is restored
+// by the next run.
+type ResolvConf struct {
+	Path   string
+	Backup string
+
+	f *os.File // the replaced file, Restore writes through it when the directory is not writable, eg: privileges dropped
+}
+
+// NewResolvConf of path, the backup is path.tun2socks
+func NewResolvConf(path string) *ResolvConf {
+	return &ResolvConf{Path: path, Backup: path + ".tun2socks"}
+}
+
+// HasBackup report whether there is a backup which is not restored
+func (r *ResolvConf) HasBackup() bool {
+	_, err := os.Lstat(r.Backup)
+	return err == nil
+}
+
+// Set back up the original file, then replace it with nameservers.
+// An existing backup is the original of an unfinished run and is kept.
+func (r *ResolvConf) Set(nameservers ...string) error {
+	if !r.HasBackup() {
+		if err := r.backup(); err != nil {
+			return fmt.Errorf("back up %s: %v", r.Path, err)
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# generated by tun2socks, the original is %s\n", r.Backup)
+	for _, ns := range nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+	if err := WriteFileAtomic(r.Path, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	if f, err := os.OpenFile(r.Path, os.O_WRONLY, 0); err == nil {
+		r.f = f
+	}
+	return nil
+}
+
+func (r *ResolvConf) backup() error {
+	if target, err := os.Readlink(r.Path); err == nil {
+		return os.Symlink(target, r.Backup)
+	}
+	info, err := os.Stat(r.Path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(r.Backup, data, info.Mode().Perm())
+}
+
+// Restore the backup and remove it, it is fine if there is no backup.
+// If the directory is not writable, the original content is written into the replaced file
+// and the backup is kept, so the next run restores it again, eg: a symlink.
+func (r *ResolvConf) Restore() error {
+	defer func() {
+		if r.f != nil {
+			r.f.Close()
+			r.f = nil
+		}
+	}()
+	if !r.HasBackup() {
+		return nil
+	}
+
+	err := r.restore()
+	if err == nil || !os.IsPermission(err) || r.f == nil {
+		return err
+	}
+	data, rerr := ioutil.ReadFile(r.Backup)
+	if rerr == nil {
+		if rerr = r.f.Truncate(0); rerr == nil {
+			_, rerr = r.f.WriteAt(data, 0)
+		}
+	}
+	if rerr != nil {
+		return fmt.Errorf("%v, write in place: %v", err, rerr)
+	}
+	log.Printf("[dns] %s is restored in place, %s is kept: %v", r.Path, r.Backup, err)
+	return nil
+}
+
+func (r *ResolvConf) restore() error {
+	target, err := os.Readlink(r.Backup)
+	if err != nil {
+		return os.Rename(r.Backup, r.Path)
+	}
+	tmp := r.Path + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, r.Path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(r.Backup)
+}
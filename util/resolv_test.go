@@ -0,0 +1,59 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvConfFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolv")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "resolv.conf")
+	original := "search lan\nnameserver 192.168.1.1\n"
+	require.Nil(t, ioutil.WriteFile(path, []byte(original), 0600))
+
+	r := NewResolvConf(path)
+	require.Nil(t, r.Set("127.0.0.1"))
+	data, _ := ioutil.ReadFile(path)
+	assert.Contains(t, string(data), "nameserver 127.0.0.1\n")
+	assert.True(t, r.HasBackup())
+
+	// a crashed run sets again, the backup is still the original
+	require.Nil(t, NewResolvConf(path).Set("127.0.0.1"))
+
+	require.Nil(t, NewResolvConf(path).Restore())
+	data, _ = ioutil.ReadFile(path)
+	assert.Equal(t, original, string(data))
+	info, _ := os.Stat(path)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.False(t, r.HasBackup())
+	assert.Nil(t, r.Restore())
+}
+
+func TestResolvConfSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolv")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "resolv.conf")
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "stub-resolv.conf"), []byte("nameserver 127.0.0.53\n"), 0644))
+	require.Nil(t, os.Symlink("stub-resolv.conf", path))
+
+	r := NewResolvConf(path)
+	require.Nil(t, r.Set("127.0.0.1"))
+	_, err = os.Readlink(path)
+	assert.NotNil(t, err, "the symlink is replaced by a file")
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "stub-resolv.conf"))
+	assert.Equal(t, "nameserver 127.0.0.53\n", string(data), "the target is untouched")
+
+	require.Nil(t, r.Restore())
+	target, err := os.Readlink(path)
+	require.Nil(t, err)
+	assert.Equal(t, "stub-resolv.conf", target)
+	assert.False(t, r.HasBackup())
+}
@@ -0,0 +1,21 @@
+package util
+
+import (
+	"fmt"
+	"net"
+)
+
+// ParseRoute parse a subnet or a host, eg: 91.108.4.0/22 or 8.8.8.8, a host is returned as a /32 or /128 subnet
+func ParseRoute(route string) (*net.IPNet, error) {
+	if _, subnet, err := net.ParseCIDR(route); err == nil {
+		return subnet, nil
+	}
+	ip := net.ParseIP(route)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid route %q", route)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
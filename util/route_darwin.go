@@ -19,3 +19,43 @@ func AddHostRoute(tun string, host string) error {
 	sargs := fmt.Sprintf("-n add -host %s -interface %s", host, tun)
 	return ExecCommand("route", sargs)
 }
+
+// DelNetRoute delete subnet route
+func DelNetRoute(tun string, subnet *net.IPNet) error {
+	ip := subnet.IP
+	maskIP := net.IP(subnet.Mask)
+	sargs := fmt.Sprintf("-n delete -net %s -netmask %s -interface %s", ip.String(), maskIP.String(), tun)
+	return ExecCommand("route", sargs)
+}
+
+// DelHostRoute delete host route
+func DelHostRoute(tun string, host string) error {
+	sargs := fmt.Sprintf("-n delete -host %s -interface %s", host, tun)
+	return ExecCommand("route", sargs)
+}
+
+// AddRoutes add subnet or host routes to tun, errs[i] is the result of routes[i]
+func AddRoutes(tun string, routes []string) []error {
+	errs := make([]error, len(routes))
+	for i, route := range routes {
+		if _, subnet, err := net.ParseCIDR(route); err == nil {
+			errs[i] = AddNetRoute(tun, subnet)
+		} else {
+			errs[i] = AddHostRoute(tun, route)
+		}
+	}
+	return errs
+}
+
+// DelRoutes delete subnet or host routes from tun, errs[i] is the result of routes[i]
+func DelRoutes(tun string, routes []string) []error {
+	errs := make([]error, len(routes))
+	for i, route := range routes {
+		if _, subnet, err := net.ParseCIDR(route); err == nil {
+			errs[i] = DelNetRoute(tun, subnet)
+		} else {
+			errs[i] = DelHostRoute(tun, route)
+		}
+	}
+	return errs
+}
@@ -1,18 +1,162 @@
 package util
 
 import (
-	"fmt"
 	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 )
 
 // AddNetRoute add subnet route
 func AddNetRoute(tun string, subnet *net.IPNet) error {
-	sargs := fmt.Sprintf("add -net %s dev %s", subnet, tun)
-	return ExecCommand("route", sargs)
+	return AddRoutes(tun, []string{subnet.String()})[0]
 }
 
 // AddHostRoute add host route
 func AddHostRoute(tun string, host string) error {
-	sargs := fmt.Sprintf("add -host %s dev %s", host, tun)
-	return ExecCommand("route", sargs)
+	return AddRoutes(tun, []string{host})[0]
+}
+
+// DelNetRoute delete subnet route
+func DelNetRoute(tun string, subnet *net.IPNet) error {
+	return DelRoutes(tun, []string{subnet.String()})[0]
+}
+
+// DelHostRoute delete host route
+func DelHostRoute(tun string, host string) error {
+	return DelRoutes(tun, []string{host})[0]
+}
+
+// routeBatchSize is the number of route messages sent at once, their acks fit in the receive buffer of the socket
+const routeBatchSize = 128
+
+// AddRoutes add subnet or host routes to tun by netlink, errs[i] is the result of routes[i].
+// The route messages are sent in batches of routeBatchSize on one netlink socket, the acks of a batch are collected after it.
+// An existing route to the same destination is replaced.
+func AddRoutes(tun string, routes []string) []error {
+	var errs []error
+	Privileged(func() error {
+		errs = sendRoutes(tun, routes, unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE)
+		return nil
+	})
+	return errs
+}
+
+// DelRoutes delete subnet or host routes from tun by netlink in batches like AddRoutes, errs[i] is the result of routes[i]
+func DelRoutes(tun string, routes []string) []error {
+	var errs []error
+	Privileged(func() error {
+		errs = sendRoutes(tun, routes, unix.RTM_DELROUTE, 0)
+		return nil
+	})
+	return errs
+}
+
+// sendRoutes send a route message of typ and flags to tun for each of routes, errs[i] is the ack of routes[i]
+func sendRoutes(tun string, routes []string, typ, flags int) []error {
+	errs := make([]error, len(routes))
+	fail := func(err error) []error {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		return errs
+	}
+
+	link, err := netlink.LinkByName(tun)
+	if err != nil {
+		return fail(err)
+	}
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return fail(err)
+	}
+	defer unix.Close(fd)
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return fail(err)
+	}
+	unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, 1<<20)
+	unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 10})
+
+	pending := make(map[uint32]int) // seq of the messages in batch -> index of routes
+	var batch []byte
+	flush := func() {
+		err := unix.Sendto(fd, batch, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+		if err == nil {
+			err = readAcks(fd, pending, errs)
+		}
+		for _, i := range pending {
+			errs[i] = err
+		}
+		pending = make(map[uint32]int)
+		batch = batch[:0]
+	}
+	for i, route := range routes {
+		dst, err := ParseRoute(route)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		req := routeRequest(typ, flags, link.Attrs().Index, dst)
+		pending[req.Seq] = i
+		batch = append(batch, req.Serialize()...)
+		if len(pending) == routeBatchSize {
+			flush()
+		}
+	}
+	if len(pending) > 0 {
+		flush()
+	}
+	return errs
+}
+
+// routeRequest build the netlink message of typ and flags for the link scope route to dst by the link
+func routeRequest(typ, flags, linkIndex int, dst *net.IPNet) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(typ, unix.NLM_F_ACK|flags)
+	msg := nl.NewRtMsg()
+	if typ == unix.RTM_DELROUTE {
+		msg = nl.NewRtDelMsg()
+	}
+	ip := dst.IP.To4()
+	msg.Family = unix.AF_INET
+	if ip == nil {
+		ip = dst.IP.To16()
+		msg.Family = unix.AF_INET6
+	}
+	ones, _ := dst.Mask.Size()
+	msg.Dst_len = uint8(ones)
+	msg.Scope = unix.RT_SCOPE_LINK
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(unix.RTA_DST, ip))
+	req.AddData(nl.NewRtAttr(unix.RTA_OIF, nl.Uint32Attr(uint32(linkIndex))))
+	return req
+}
+
+// readAcks receive the acks of the pending messages from fd, errs of their routes are set and they are removed from pending
+func readAcks(fd int, pending map[uint32]int, errs []error) error {
+	buf := make([]byte, 1<<16)
+	for len(pending) > 0 {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			i, ok := pending[m.Header.Seq]
+			if !ok || m.Header.Type != unix.NLMSG_ERROR || len(m.Data) < 4 {
+				continue
+			}
+			if code := int32(nl.NativeEndian().Uint32(m.Data[:4])); code != 0 {
+				errs[i] = syscall.Errno(-code)
+			}
+			delete(pending, m.Header.Seq)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestRouteRequest(t *testing.T) {
+	_, v4, _ := net.ParseCIDR("10.1.0.0/16")
+	_, v6, _ := net.ParseCIDR("fd00::/64")
+	add := routeRequest(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_REPLACE, 7, v4)
+	del := routeRequest(unix.RTM_DELROUTE, 0, 7, v6)
+	assert.NotEqual(t, add.Seq, del.Seq)
+
+	// the messages of a batch are parsed back one by one
+	msgs, err := syscall.ParseNetlinkMessage(append(add.Serialize(), del.Serialize()...))
+	require.Nil(t, err)
+	require.Len(t, msgs, 2)
+
+	assert.EqualValues(t, unix.RTM_NEWROUTE, msgs[0].Header.Type)
+	assert.EqualValues(t, unix.NLM_F_REQUEST|unix.NLM_F_ACK|unix.NLM_F_CREATE|unix.NLM_F_REPLACE, msgs[0].Header.Flags)
+	msg := nl.DeserializeRtMsg(msgs[0].Data)
+	assert.EqualValues(t, unix.AF_INET, msg.Family)
+	assert.EqualValues(t, 16, msg.Dst_len)
+	assert.EqualValues(t, unix.RT_SCOPE_LINK, msg.Scope)
+	assert.EqualValues(t, unix.RT_TABLE_MAIN, msg.Table)
+	attrs, err := nl.ParseRouteAttr(msgs[0].Data[unix.SizeofRtMsg:])
+	require.Nil(t, err)
+	require.Len(t, attrs, 2)
+	assert.EqualValues(t, unix.RTA_DST, attrs[0].Attr.Type)
+	assert.Equal(t, []byte{10, 1, 0, 0}, attrs[0].Value)
+	assert.EqualValues(t, unix.RTA_OIF, attrs[1].Attr.Type)
+	assert.EqualValues(t, 7, nl.NativeEndian().Uint32(attrs[1].Value))
+
+	assert.EqualValues(t, unix.RTM_DELROUTE, msgs[1].Header.Type)
+	assert.EqualValues(t, unix.NLM_F_REQUEST|unix.NLM_F_ACK, msgs[1].Header.Flags)
+	msg = nl.DeserializeRtMsg(msgs[1].Data)
+	assert.EqualValues(t, unix.AF_INET6, msg.Family)
+	assert.EqualValues(t, 64, msg.Dst_len)
+}
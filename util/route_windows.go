@@ -20,3 +20,43 @@ func AddHostRoute(_ string, host string) error {
 	sargs := fmt.Sprintf("add %s mask 255.255.255.255 %s", host, host)
 	return ExecCommand("route", sargs)
 }
+
+// DelNetRoute delete subnet route
+func DelNetRoute(_ string, subnet *net.IPNet) error {
+	ip := subnet.IP
+	maskIP := net.IP(subnet.Mask)
+	sargs := fmt.Sprintf("delete %s mask %s", ip, maskIP)
+	return ExecCommand("route", sargs)
+}
+
+// DelHostRoute delete host route
+func DelHostRoute(_ string, host string) error {
+	sargs := fmt.Sprintf("delete %s mask 255.255.255.255", host)
+	return ExecCommand("route", sargs)
+}
+
+// AddRoutes add subnet or host routes to tun, errs[i] is the result of routes[i]
+func AddRoutes(tun string, routes []string) []error {
+	errs := make([]error, len(routes))
+	for i, route := range routes {
+		if _, subnet, err := net.ParseCIDR(route); err == nil {
+			errs[i] = AddNetRoute(tun, subnet)
+		} else {
+			errs[i] = AddHostRoute(tun, route)
+		}
+	}
+	return errs
+}
+
+// DelRoutes delete subnet or host routes from tun, errs[i] is the result of routes[i]
+func DelRoutes(tun string, routes []string) []error {
+	errs := make([]error, len(routes))
+	for i, route := range routes {
+		if _, subnet, err := net.ParseCIDR(route); err == nil {
+			errs[i] = DelNetRoute(tun, subnet)
+		} else {
+			errs[i] = DelHostRoute(tun, route)
+		}
+	}
+	return errs
+}
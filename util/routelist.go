@@ -0,0 +1,191 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReadRouteList read a route list, one subnet or host per line, # starts a comment.
+// Lines of the APNIC delegated style, eg: apnic|CN|ipv4|1.0.1.0|256|20110414|allocated,
+// are read too, only the ones of country if it is not empty.
+func ReadRouteList(r io.Reader, country string) ([]*net.IPNet, error) {
+	var routes []*net.IPNet
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.Contains(line, "|") {
+			route, err := ParseRoute(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			routes = append(routes, route)
+			continue
+		}
+		apnic, err := parseDelegated(line, country)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		routes = append(routes, apnic...)
+	}
+	return routes, scanner.Err()
+}
+
+// parseDelegated parse a line of the delegated file of a registry: registry|cc|type|start|value|date|status,
+// value is the number of addresses of ipv4 and the prefix length of ipv6. Other lines, eg: the version and
+// summary lines, are skipped.
+func parseDelegated(line, country string) ([]*net.IPNet, error) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 7 || fields[3] == "*" || (fields[2] != "ipv4" && fields[2] != "ipv6") {
+		return nil, nil
+	}
+	if country != "" && !strings.EqualFold(fields[1], country) {
+		return nil, nil
+	}
+	ip := net.ParseIP(fields[3])
+	value, err := strconv.ParseUint(fields[4], 10, 64)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid delegated record %q", line)
+	}
+	if fields[2] == "ipv6" {
+		if ip.To4() != nil || value > 128 {
+			return nil, fmt.Errorf("invalid delegated record %q", line)
+		}
+		mask := net.CIDRMask(int(value), 128)
+		return []*net.IPNet{{IP: ip.Mask(mask), Mask: mask}}, nil
+	}
+	ip4 := ip.To4()
+	if ip4 == nil || value == 0 || uint64(binary.BigEndian.Uint32(ip4))+value > 1<<32 {
+		return nil, fmt.Errorf("invalid delegated record %q", line)
+	}
+	return rangeToRoutes(binary.BigEndian.Uint32(ip4), value), nil
+}
+
+// rangeToRoutes split count ipv4 addresses from start into subnets
+func rangeToRoutes(start uint32, count uint64) []*net.IPNet {
+	var routes []*net.IPNet
+	for count > 0 {
+		// the largest block aligned at start which is not larger than count
+		size := uint(bits.TrailingZeros32(start))
+		if start == 0 {
+			size = 32
+		}
+		for uint64(1)<<size > count {
+			size--
+		}
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, start)
+		routes = append(routes, &net.IPNet{IP: ip, Mask: net.CIDRMask(32-int(size), 32)})
+		start += uint32(uint64(1) << size)
+		count -= uint64(1) << size
+	}
+	return routes
+}
+
+// SubtractRoutes return the subnets of include which are not in exclude, sorted by family and address.
+// An include which overlaps an exclude is split into the subnets around it. Both lists are sorted first,
+// so the excludes are walked once along the includes.
+func SubtractRoutes(include, exclude []*net.IPNet) []*net.IPNet {
+	include = sortRoutes(include)
+	// subnets either nest or do not overlap, so the excludes left are disjoint
+	var excludes []*net.IPNet
+	for _, ex := range sortRoutes(exclude) {
+		if n := len(excludes); n > 0 && compareAddr(ex, firstAddr(ex), excludes[n-1], lastAddr(excludes[n-1])) <= 0 {
+			continue
+		}
+		excludes = append(excludes, ex)
+	}
+
+	var routes []*net.IPNet
+	j := 0
+	for _, route := range include {
+		// the excludes before route are before the next includes too
+		for j < len(excludes) && compareAddr(excludes[j], lastAddr(excludes[j]), route, firstAddr(route)) < 0 {
+			j++
+		}
+		rest := []*net.IPNet{route}
+		for k := j; k < len(excludes) && compareAddr(excludes[k], firstAddr(excludes[k]), route, lastAddr(route)) <= 0; k++ {
+			var next []*net.IPNet
+			for _, r := range rest {
+				next = subtractRoute(next, r, excludes[k])
+			}
+			rest = next
+		}
+		routes = append(routes, rest...)
+	}
+	return routes
+}
+
+// sortRoutes return a sorted copy of routes, by family, first address, then the larger subnet first
+func sortRoutes(routes []*net.IPNet) []*net.IPNet {
+	sorted := make([]*net.IPNet, len(routes))
+	copy(sorted, routes)
+	sort.Slice(sorted, func(i, j int) bool {
+		if c := compareAddr(sorted[i], firstAddr(sorted[i]), sorted[j], firstAddr(sorted[j])); c != 0 {
+			return c < 0
+		}
+		a, _ := sorted[i].Mask.Size()
+		b, _ := sorted[j].Mask.Size()
+		return a < b
+	})
+	return sorted
+}
+
+// compareAddr compare the address a of route ra with the address b of route rb, ipv4 is before ipv6
+func compareAddr(ra *net.IPNet, a net.IP, rb *net.IPNet, b net.IP) int {
+	_, sa := ra.Mask.Size()
+	_, sb := rb.Mask.Size()
+	if sa != sb {
+		return sa - sb
+	}
+	return bytes.Compare(a, b)
+}
+
+// firstAddr of a route, in the length of its mask
+func firstAddr(route *net.IPNet) net.IP {
+	return route.IP.Mask(route.Mask)
+}
+
+// lastAddr of a route, in the length of its mask
+func lastAddr(route *net.IPNet) net.IP {
+	ip := firstAddr(route)
+	for i := range ip {
+		ip[i] |= ^route.Mask[i]
+	}
+	return ip
+}
+
+// subtractRoute append route minus ex to rest
+func subtractRoute(rest []*net.IPNet, route, ex *net.IPNet) []*net.IPNet {
+	ones, size := route.Mask.Size()
+	exOnes, exSize := ex.Mask.Size()
+	if size != exSize || !route.Contains(ex.IP) && !ex.Contains(route.IP) {
+		return append(rest, route)
+	}
+	if exOnes <= ones {
+		// ex covers route
+		return rest
+	}
+	// split route into halves, one of them contains ex
+	mask := net.CIDRMask(ones+1, size)
+	low := &net.IPNet{IP: route.IP.Mask(route.Mask), Mask: mask}
+	high := &net.IPNet{IP: make(net.IP, len(low.IP)), Mask: mask}
+	copy(high.IP, low.IP)
+	high.IP[ones/8] |= 0x80 >> uint(ones%8)
+	rest = subtractRoute(rest, low, ex)
+	return subtractRoute(rest, high, ex)
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func routeStrings(routes []*net.IPNet) []string {
+	var s []string
+	for _, r := range routes {
+		s = append(s, r.String())
+	}
+	return s
+}
+
+func TestReadRouteList(t *testing.T) {
+	list := `# plain
+91.108.4.0/22
+8.8.8.8 # a host
+2001:db8::/32
+
+2|apnic|20180101|1|19830613|20180101|+1000
+apnic|*|ipv4|*|1|summary
+apnic|CN|ipv4|1.0.1.0|256|20110414|allocated
+apnic|CN|ipv4|1.0.2.0|768|20110414|allocated
+apnic|JP|ipv4|1.0.16.0|4096|20110412|allocated
+apnic|CN|ipv6|2001:250::|35|20000426|allocated
+`
+	routes, err := ReadRouteList(strings.NewReader(list), "cn")
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"91.108.4.0/22", "8.8.8.8/32", "2001:db8::/32",
+		"1.0.1.0/24",
+		"1.0.2.0/23", "1.0.4.0/24",
+		"2001:250::/35",
+	}, routeStrings(routes))
+
+	routes, err = ReadRouteList(strings.NewReader(list), "")
+	require.Nil(t, err)
+	assert.Contains(t, routeStrings(routes), "1.0.16.0/20")
+
+	_, err = ReadRouteList(strings.NewReader("1.0.0.0/8\nnot a route\n"), "")
+	assert.EqualError(t, err, `line 2: invalid route "not a route"`)
+}
+
+func TestSubtractRoutes(t *testing.T) {
+	parse := func(s ...string) []*net.IPNet {
+		var routes []*net.IPNet
+		for _, r := range s {
+			route, err := ParseRoute(r)
+			require.Nil(t, err)
+			routes = append(routes, route)
+		}
+		return routes
+	}
+
+	routes := SubtractRoutes(parse("10.0.0.0/8", "192.168.0.0/16", "2001:db8::/32"), parse("10.0.0.0/10", "192.168.0.0/16", "2001:db8::/33"))
+	assert.Equal(t, []string{"10.64.0.0/10", "10.128.0.0/9", "2001:db8:8000::/33"}, routeStrings(routes))
+
+	routes = SubtractRoutes(parse("1.0.0.0/30"), parse("1.0.0.1"))
+	assert.Equal(t, []string{"1.0.0.0/32", "1.0.0.2/31"}, routeStrings(routes))
+
+	routes = SubtractRoutes(parse("1.0.0.0/24"), parse("2.0.0.0/8"))
+	assert.Equal(t, []string{"1.0.0.0/24"}, routeStrings(routes))
+
+	// unsorted lists, nested excludes and several excludes in one include
+	routes = SubtractRoutes(
+		parse("2001:db8::/32", "9.0.0.0/8", "1.0.0.0/30", "10.0.0.0/8"),
+		parse("10.128.0.0/9", "10.0.0.0/10", "10.0.0.0/16", "1.0.0.1", "1.0.0.3", "8.0.0.0/8"),
+	)
+	assert.Equal(t, []string{"1.0.0.0/32", "1.0.0.2/32", "9.0.0.0/8", "10.64.0.0/10", "2001:db8::/32"}, routeStrings(routes))
+
+	// an exclude covering several includes
+	routes = SubtractRoutes(parse("10.2.0.0/16", "10.1.0.0/16", "11.0.0.0/8"), parse("10.0.0.0/8"))
+	assert.Equal(t, []string{"11.0.0.0/8"}, routeStrings(routes))
+}
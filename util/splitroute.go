@@ -0,0 +1,49 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SplitRoute route the sockets of some local users and cgroups into a tun by policy routing, the others are untouched.
+// The default route is installed into Table, the UIDs select by uid range and the Cgroups by Mark,
+// which is set on the packets of their sockets. The sockets marked with FWMark, the Exclude destinations
+// and non-default routes of the main table keep using the main table.
+type SplitRoute struct {
+	Tun      string
+	Table    int
+	Mark     int
+	FWMark   int // mark of the sockets opened by tun2socks, so they do not loop back if it runs as one of the UIDs
+	Priority int // priority of the first rule, the rules take Priority to Priority+2
+	UIDs     []UIDRange
+	Cgroups  []string // cgroup v2 paths relative to /sys/fs/cgroup, eg: user.slice/user-1000.slice
+	Exclude  []*net.IPNet
+}
+
+// UIDRange is the uids from Start to End, both included
+type UIDRange struct {
+	Start, End uint32
+}
+
+func (r UIDRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// ParseUIDRange parse a uid, eg: 1000, or a uid range, eg: 1000-1999
+func ParseUIDRange(s string) (UIDRange, error) {
+	start, end := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		start, end = s[:i], s[i+1:]
+	}
+	a, err := strconv.ParseUint(strings.TrimSpace(start), 10, 32)
+	if err != nil {
+		return UIDRange{}, fmt.Errorf("invalid uid range %q", s)
+	}
+	b, err := strconv.ParseUint(strings.TrimSpace(end), 10, 32)
+	if err != nil || b < a {
+		return UIDRange{}, fmt.Errorf("invalid uid range %q", s)
+	}
+	return UIDRange{Start: uint32(a), End: uint32(b)}, nil
+}
@@ -0,0 +1,181 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// splitRouteTable mark the packets of the cgroups
+var splitRouteTable = &nftables.Table{Name: "tun2socks-split", Family: nftables.TableFamilyINet}
+
+// Install the default route, the rules and the cgroup marks, the ones left by a crashed run are removed first
+//
+//	ip route add default dev Tun table Table
+//	ip rule add fwmark FWMark lookup main priority Priority
+//	ip rule add to Exclude lookup main priority Priority
+//	ip rule add lookup main suppress_prefixlength 0 priority Priority+1
+//	ip rule add uidrange UIDs lookup Table priority Priority+2
+//	ip rule add fwmark Mark lookup Table priority Priority+2
+//	nft add rule inet tun2socks-split output meta mark != FWMark socket cgroupv2 level 2 "Cgroups" meta mark set Mark
+func (r *SplitRoute) Install() error {
+	return Privileged(r.install)
+}
+
+func (r *SplitRoute) install() error {
+	r.remove()
+
+	h, err := netlink.NewHandle()
+	if err != nil {
+		return err
+	}
+	defer h.Delete()
+	if err := addDefaultRoute(h, r.Tun, r.Table); err != nil {
+		return err
+	}
+
+	if err := addRules(h, r.rules()); err != nil {
+		r.remove()
+		return err
+	}
+
+	if len(r.Cgroups) > 0 {
+		if err := r.markCgroups(); err != nil {
+			r.remove()
+			return err
+		}
+	}
+	return nil
+}
+
+// rules of the policy routing, in Priority to Priority+2
+func (r *SplitRoute) rules() []*netlink.Rule {
+	var rules []*netlink.Rule
+	if r.FWMark != 0 {
+		exempt := netlink.NewRule()
+		exempt.Priority = r.Priority
+		exempt.Mark = uint32(r.FWMark)
+		exempt.Table = unix.RT_TABLE_MAIN
+		rules = append(rules, exempt)
+	}
+	for _, dst := range r.Exclude {
+		rule := netlink.NewRule()
+		rule.Priority = r.Priority
+		rule.Dst = dst
+		rule.Table = unix.RT_TABLE_MAIN
+		rules = append(rules, rule)
+	}
+	main := netlink.NewRule()
+	main.Priority = r.Priority + 1
+	main.Table = unix.RT_TABLE_MAIN
+	main.SuppressPrefixlen = 0
+	rules = append(rules, main)
+	for _, uids := range r.UIDs {
+		rule := netlink.NewRule()
+		rule.Priority = r.Priority + 2
+		rule.UIDRange = netlink.NewRuleUIDRange(uids.Start, uids.End)
+		rule.Table = r.Table
+		rules = append(rules, rule)
+	}
+	if len(r.Cgroups) > 0 {
+		rule := netlink.NewRule()
+		rule.Priority = r.Priority + 2
+		rule.Mark = uint32(r.Mark)
+		rule.Table = r.Table
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// markCgroups set Mark on the packets of the sockets in Cgroups, in a route chain so they are routed again by the mark
+func (r *SplitRoute) markCgroups() error {
+	c := &nftables.Conn{}
+	replaceNftTable(c, splitRouteTable)
+	chain := c.AddChain(&nftables.Chain{
+		Name:     "output",
+		Table:    splitRouteTable,
+		Type:     nftables.ChainTypeRoute,
+		Hooknum:  nftables.ChainHookOutput,
+		Priority: nftables.ChainPriorityMangle,
+	})
+	mark := binaryutil.NativeEndian.PutUint32(uint32(r.Mark))
+	for _, cgroup := range r.Cgroups {
+		id, level, err := cgroupID(cgroup)
+		if err != nil {
+			return err
+		}
+		var exprs []expr.Any
+		if r.FWMark != 0 {
+			// the marked sockets of tun2socks keep their mark if it runs in one of the cgroups
+			exprs = append(exprs,
+				&expr.Meta{Key: expr.MetaKeyMARK, Register: 1},
+				&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(uint32(r.FWMark))},
+			)
+		}
+		exprs = append(exprs,
+			&expr.Socket{Key: expr.SocketKeyCgroupv2, Level: level, Register: 1},
+			cmp(binaryutil.NativeEndian.PutUint64(id)),
+			&expr.Immediate{Register: 1, Data: mark},
+			&expr.Meta{Key: expr.MetaKeyMARK, SourceRegister: true, Register: 1},
+		)
+		c.AddRule(&nftables.Rule{
+			Table: splitRouteTable,
+			Chain: chain,
+			Exprs: exprs,
+		})
+	}
+	if err := c.Flush(); err != nil {
+		return fmt.Errorf("mark cgroups: %v", err)
+	}
+	return nil
+}
+
+// Remove the rules, the default route and the cgroup marks, errors of missing ones are ignored
+func (r *SplitRoute) Remove() error {
+	return Privileged(r.remove)
+}
+
+func (r *SplitRoute) remove() error {
+	h, err := netlink.NewHandle()
+	if err != nil {
+		return err
+	}
+	defer h.Delete()
+
+	errs := delRules(h, r.Priority, r.Priority+2)
+	errs = append(errs, delDefaultRoute(h, r.Table)...)
+	if err := delNftTable(splitRouteTable); err != nil {
+		errs = append(errs, fmt.Errorf("delete cgroup marks: %v", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+	return nil
+}
+
+// cgroupID return the id of a cgroup v2, which is the inode of its directory, and its level, which is its depth
+func cgroupID(cgroup string) (uint64, uint32, error) {
+	cgroup = strings.Trim(filepath.Clean("/"+cgroup), "/")
+	info, err := os.Stat(filepath.Join(cgroupRoot, cgroup))
+	if err != nil {
+		return 0, 0, fmt.Errorf("cgroup %s: %v", cgroup, err)
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || !info.IsDir() {
+		return 0, 0, fmt.Errorf("cgroup %s is not a directory", cgroup)
+	}
+	if cgroup == "" {
+		return st.Ino, 0, nil
+	}
+	return st.Ino, uint32(strings.Count(cgroup, "/") + 1), nil
+}
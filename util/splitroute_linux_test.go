@@ -0,0 +1,47 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestSplitRouteRules(t *testing.T) {
+	r := &SplitRoute{
+		Tun:      "tun0",
+		Table:    2022,
+		Mark:     2023,
+		FWMark:   2022,
+		Priority: 9000,
+		UIDs:     []UIDRange{{Start: 1000, End: 1000}},
+		Cgroups:  []string{"user.slice"},
+	}
+	rules := r.rules()
+	assert.Len(t, rules, 4)
+
+	// the sockets of tun2socks are exempted first, even if it runs as one of the uids
+	assert.Equal(t, 9000, rules[0].Priority)
+	assert.EqualValues(t, 2022, rules[0].Mark)
+	assert.False(t, rules[0].Invert)
+	assert.Equal(t, unix.RT_TABLE_MAIN, rules[0].Table)
+
+	assert.Equal(t, 9001, rules[1].Priority)
+	assert.Equal(t, 0, rules[1].SuppressPrefixlen)
+
+	assert.Equal(t, 9002, rules[2].Priority)
+	assert.Equal(t, netlink.NewRuleUIDRange(1000, 1000), rules[2].UIDRange)
+	assert.Equal(t, 2022, rules[2].Table)
+
+	assert.Equal(t, 9002, rules[3].Priority)
+	assert.EqualValues(t, 2023, rules[3].Mark)
+	assert.Equal(t, 2022, rules[3].Table)
+
+	// no fwmark rule without a mark, it would match every socket
+	r.FWMark = 0
+	r.Cgroups = nil
+	rules = r.rules()
+	assert.Len(t, rules, 2)
+	assert.Equal(t, 9001, rules[0].Priority)
+}
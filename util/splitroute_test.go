@@ -0,0 +1,23 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUIDRange(t *testing.T) {
+	r, err := ParseUIDRange("1000")
+	assert.Nil(t, err)
+	assert.Equal(t, UIDRange{Start: 1000, End: 1000}, r)
+
+	r, err = ParseUIDRange("1000-1999")
+	assert.Nil(t, err)
+	assert.Equal(t, UIDRange{Start: 1000, End: 1999}, r)
+	assert.Equal(t, "1000-1999", r.String())
+
+	for _, s := range []string{"", "abc", "-1", "1999-1000", "1000-", "1-2-3"} {
+		_, err = ParseUIDRange(s)
+		assert.NotNil(t, err, s)
+	}
+}
@@ -0,0 +1,17 @@
+package util
+
+import (
+	"errors"
+)
+
+var errSplitRoute = errors.New("split tunnelling by uid and cgroup is only supported on linux")
+
+// Install is not supported
+func (r *SplitRoute) Install() error {
+	return errSplitRoute
+}
+
+// Remove is not supported
+func (r *SplitRoute) Remove() error {
+	return errSplitRoute
+}